@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"go.yaml.in/yaml/v3"
+	"golang.org/x/time/rate"
+
+	"github.com/robbydyer/namerouter/internal/namerouter"
+)
+
+func newConfigCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Inspect configuration",
+	}
+
+	show := &cobra.Command{
+		Use:   "show",
+		Short: "Show the effective configuration, after defaults and overrides are applied",
+		RunE:  runConfigShow,
+	}
+	addConfigFileFlag(show.Flags())
+	addOverrideFlags(show.Flags())
+
+	cmd.AddCommand(show)
+
+	return cmd
+}
+
+func runConfigShow(cmd *cobra.Command, args []string) error {
+	if err := bindFlags(cmd); err != nil {
+		return err
+	}
+
+	configFile := viper.GetString("config-file")
+	if configFile == "" {
+		return fmt.Errorf("missing --config-file")
+	}
+
+	config, err := namerouter.LoadConfig(configFile)
+	if err != nil {
+		return err
+	}
+	applyOverrides(config)
+
+	effective, err := namerouter.Effective(config, routerOptions()...)
+	if err != nil {
+		return err
+	}
+
+	enc := yaml.NewEncoder(os.Stdout)
+	enc.SetIndent(2)
+	if err := enc.Encode(effective); err != nil {
+		return err
+	}
+
+	return enc.Close()
+}
+
+// addConfigFileFlag adds the --config-file flag. Like every flag, it can
+// also be set with a NAMEROUTER_ prefixed environment variable, in this
+// case NAMEROUTER_CONFIG_FILE.
+func addConfigFileFlag(f *pflag.FlagSet) {
+	f.String("config-file", "", "config file name (YAML, JSON or TOML)")
+}
+
+// addOverrideFlags adds flags for the config values that can be overridden
+// from the command line or environment
+func addOverrideFlags(f *pflag.FlagSet) {
+	f.Bool("debug", false, "Debug mode")
+	f.Bool("do-ssl", false, "Enable TLS")
+	f.String("email", "", "Email used for Letsencrypt cert generation")
+	f.Int("https-port", 0, "HTTPS listen port")
+	f.Int("http-port", 0, "HTTP listen port")
+	f.Float64("internal-rate", 0, "Requests per second allowed from internal clients")
+	f.Int("internal-burst", 0, "Request burst allowed from internal clients")
+	f.Float64("external-rate", 0, "Requests per second allowed from external clients")
+	f.Int("external-burst", 0, "Request burst allowed from external clients")
+}
+
+// bindFlags binds the command's flags to viper, so that values are looked up
+// from flags first, then NAMEROUTER_* environment variables
+func bindFlags(cmd *cobra.Command) error {
+	if err := viper.BindPFlags(cmd.Flags()); err != nil {
+		return fmt.Errorf("failed to bind flags: %w", err)
+	}
+	return nil
+}
+
+// applyOverrides sets any config values that were given as flags or
+// environment variables. These take precedence over the config file.
+func applyOverrides(c *namerouter.Config) {
+	c.SetDefaults()
+
+	if viper.IsSet("debug") {
+		c.Debug = viper.GetBool("debug")
+	}
+	if viper.IsSet("do-ssl") {
+		c.DoSSL = viper.GetBool("do-ssl")
+	}
+	if viper.IsSet("email") {
+		c.Email = viper.GetString("email")
+	}
+	if viper.IsSet("https-port") {
+		c.HTTPSPort = viper.GetInt("https-port")
+	}
+	if viper.IsSet("http-port") {
+		c.HTTPPort = viper.GetInt("http-port")
+	}
+	if viper.IsSet("internal-rate") {
+		c.RateLimits.Internal.Rate = rate.Limit(viper.GetFloat64("internal-rate"))
+	}
+	if viper.IsSet("internal-burst") {
+		c.RateLimits.Internal.Burst = viper.GetInt("internal-burst")
+	}
+	if viper.IsSet("external-rate") {
+		c.RateLimits.External.Rate = rate.Limit(viper.GetFloat64("external-rate"))
+	}
+	if viper.IsSet("external-burst") {
+		c.RateLimits.External.Burst = viper.GetInt("external-burst")
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/robbydyer/namerouter/internal/namerouter"
+)
+
+func TestApplyOverrides(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	newRootCmd()
+
+	t.Setenv("NAMEROUTER_HTTP_PORT", "8081")
+	t.Setenv("NAMEROUTER_EMAIL", "env@example.com")
+	t.Setenv("NAMEROUTER_EXTERNAL_RATE", "2.5")
+
+	cmd := &cobra.Command{}
+	addOverrideFlags(cmd.Flags())
+	if err := cmd.Flags().Set("email", "flag@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := bindFlags(cmd); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &namerouter.Config{HTTPPort: 80, HTTPSPort: 8443, Email: "file@example.com"}
+	applyOverrides(c)
+
+	if c.Email != "flag@example.com" {
+		t.Errorf("email = %q, want the flag", c.Email)
+	}
+	if c.HTTPPort != 8081 {
+		t.Errorf("httpPort = %d, want the environment variable", c.HTTPPort)
+	}
+	if c.HTTPSPort != 8443 {
+		t.Errorf("httpsPort = %d, want the config file", c.HTTPSPort)
+	}
+	if c.RateLimits.External.Rate != 2.5 {
+		t.Errorf("external rate = %v, want the environment variable", c.RateLimits.External.Rate)
+	}
+	if c.RateLimits.Internal.Burst == 0 {
+		t.Error("internal burst wasn't defaulted")
+	}
+}
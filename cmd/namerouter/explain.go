@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/robbydyer/namerouter/internal/namerouter"
+)
+
+type explainCmd struct {
+	req     *namerouter.ExplainRequest
+	headers []string
+	cookies []string
+}
+
+func newExplainCmd() *cobra.Command {
+	e := &explainCmd{
+		req: &namerouter.ExplainRequest{},
+	}
+
+	cmd := &cobra.Command{
+		Use:   "explain",
+		Short: "Explain how a request would be routed",
+		RunE:  e.run,
+	}
+
+	f := cmd.Flags()
+
+	addConfigFileFlag(f)
+	addOverrideFlags(f)
+	f.StringVar(&e.req.Host, "host", "", "Host header of the request")
+	f.StringVar(&e.req.Path, "path", "/", "Request path, including any query")
+	f.StringVar(&e.req.ClientIP, "client-ip", "", "IP address of the client")
+	f.IntVar(&e.req.LocalPort, "local-port", 0, "Port the request arrives on. Defaults to the listener port for the scheme")
+	f.StringVar(&e.req.Scheme, "scheme", "", "http or https. Defaults to https when doSSL is on")
+	f.StringVar(&e.req.Method, "method", "GET", "Request method")
+	f.StringArrayVar(&e.headers, "header", nil, "Request header as 'Name: value'. Can be repeated")
+	f.StringArrayVar(&e.cookies, "cookie", nil, "Request cookie as 'name=value'. Can be repeated")
+
+	return cmd
+}
+
+func (e *explainCmd) run(cmd *cobra.Command, args []string) error {
+	if err := bindFlags(cmd); err != nil {
+		return err
+	}
+
+	configFile := viper.GetString("config-file")
+	if configFile == "" {
+		return fmt.Errorf("missing --config-file")
+	}
+	if e.req.ClientIP == "" {
+		return fmt.Errorf("missing --client-ip")
+	}
+
+	e.req.Header = http.Header{}
+	for _, h := range e.headers {
+		name, value, ok := strings.Cut(h, ":")
+		if !ok {
+			return fmt.Errorf("invalid --header %q, must be 'Name: value'", h)
+		}
+		e.req.Header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	for _, c := range e.cookies {
+		if !strings.Contains(c, "=") {
+			return fmt.Errorf("invalid --cookie %q, must be 'name=value'", c)
+		}
+		e.req.Header.Add("Cookie", c)
+	}
+
+	config, err := namerouter.LoadConfig(configFile)
+	if err != nil {
+		return err
+	}
+	applyOverrides(config)
+
+	explanation, err := namerouter.Explain(config, e.req)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("request: %s %s://%s%s from %s on port %d\n",
+		e.req.Method, e.req.Scheme, e.req.Host, e.req.Path, e.req.ClientIP, e.req.LocalPort)
+	fmt.Println(explanation.String())
+
+	return nil
+}
@@ -38,6 +38,9 @@ func newRootCmd() *cobra.Command {
 
 	rootCmd.AddCommand(
 		newRunCmd(),
+		newValidateCmd(),
+		newExplainCmd(),
+		newConfigCmd(),
 	)
 
 	return rootCmd
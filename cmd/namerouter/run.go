@@ -5,17 +5,20 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/spf13/cobra"
-	"gopkg.in/yaml.v2"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/acme/autocert"
 
 	"github.com/robbydyer/namerouter/internal/namerouter"
 )
 
 type runCmd struct {
 	configFile string
-	debug      bool
+	watch      bool
 }
 
 func newRunCmd() *cobra.Command {
@@ -28,45 +31,87 @@ func newRunCmd() *cobra.Command {
 
 	f := cmd.Flags()
 
-	f.StringVar(&r.configFile, "config-file", "", "config file name")
-	f.BoolVar(&r.debug, "debug", false, "Debug mode")
+	addConfigFileFlag(f)
+	addOverrideFlags(f)
+	f.Bool("watch", true, "Reload the config file when it changes")
 
 	return cmd
 }
 
 func (r *runCmd) run(cmd *cobra.Command, args []string) error {
+	if err := bindFlags(cmd); err != nil {
+		return err
+	}
+
+	r.configFile = viper.GetString("config-file")
+	r.watch = viper.GetBool("watch")
+
 	if r.configFile == "" {
 		return fmt.Errorf("missing --config-file")
 	}
 
-	var configData *namerouter.Config
-
-	data, err := os.ReadFile(r.configFile)
+	configData, err := r.loadConfig()
 	if err != nil {
 		return err
 	}
 
-	if err := yaml.Unmarshal(data, &configData); err != nil {
-		return fmt.Errorf("failed to parse config file: %w", err)
-	}
-
-	if r.debug {
-		configData.Debug = true
-	}
-
-	nr, err := namerouter.New(configData)
+	nr, err := namerouter.New(configData, routerOptions()...)
 	if err != nil {
 		return err
 	}
 
+	watchCtx, stopWatching := context.WithCancel(context.Background())
+	defer stopWatching()
+
 	go func() {
 		c := make(chan os.Signal, 1)
 		signal.Notify(c, os.Interrupt)
 		<-c
+		stopWatching()
 		stopCtx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 		defer cancel()
 		nr.Shutdown(stopCtx)
 	}()
 
+	go func() {
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, syscall.SIGHUP)
+		for {
+			select {
+			case <-watchCtx.Done():
+				return
+			case <-c:
+				_ = nr.ReloadFrom(r.loadConfig)
+			}
+		}
+	}()
+
+	if r.watch {
+		go func() {
+			if err := nr.WatchConfig(watchCtx, r.configFile, r.loadConfig); err != nil {
+				nr.Logger().Error("config watcher failed", zap.Error(err))
+			}
+		}()
+	}
+
 	return nr.Start()
 }
+
+// routerOptions are the options the router is run with
+func routerOptions() []namerouter.Option {
+	return []namerouter.Option{
+		namerouter.WithHealthServer(":9000"),
+		namerouter.WithCertCache(autocert.DirCache("/cert_cache")),
+	}
+}
+
+func (r *runCmd) loadConfig() (*namerouter.Config, error) {
+	configData, err := namerouter.LoadConfig(r.configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	applyOverrides(configData)
+
+	return configData, nil
+}
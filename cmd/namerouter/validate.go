@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/robbydyer/namerouter/internal/namerouter"
+)
+
+type validateCmd struct {
+	configFile string
+}
+
+func newValidateCmd() *cobra.Command {
+	v := &validateCmd{}
+
+	cmd := &cobra.Command{
+		Use:   "validate",
+		Short: "Validate a config file",
+		RunE:  v.run,
+	}
+
+	addConfigFileFlag(cmd.Flags())
+
+	return cmd
+}
+
+func (v *validateCmd) run(cmd *cobra.Command, args []string) error {
+	if err := bindFlags(cmd); err != nil {
+		return err
+	}
+
+	v.configFile = viper.GetString("config-file")
+
+	if v.configFile == "" {
+		return fmt.Errorf("missing --config-file")
+	}
+
+	err := namerouter.ValidateFile(v.configFile)
+	if err == nil {
+		fmt.Printf("%s is valid\n", v.configFile)
+		return nil
+	}
+
+	var vErrs namerouter.ValidationErrors
+	if !errors.As(err, &vErrs) {
+		return err
+	}
+
+	for _, e := range vErrs {
+		fmt.Println(e.Error())
+	}
+
+	return fmt.Errorf("%s is invalid: %d problem(s) found", v.configFile, len(vErrs))
+}
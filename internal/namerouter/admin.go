@@ -0,0 +1,226 @@
+package namerouter
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"go.yaml.in/yaml/v3"
+)
+
+// maxAdminBody is the largest request body the admin API accepts
+const maxAdminBody = 1 << 20
+
+func (n *NameRouter) adminRouter() http.Handler {
+	router := mux.NewRouter()
+
+	router.HandleFunc("/routes", n.adminListRoutes).Methods(http.MethodGet)
+	router.HandleFunc("/routes", n.adminCreateRoute).Methods(http.MethodPost)
+	router.HandleFunc("/routes/{name}", n.adminGetRoute).Methods(http.MethodGet)
+	router.HandleFunc("/routes/{name}", n.adminPatchRoute).Methods(http.MethodPatch)
+	router.HandleFunc("/routes/{name}", n.adminDeleteRoute).Methods(http.MethodDelete)
+
+	router.Use(n.adminAuth)
+
+	return router
+}
+
+func (n *NameRouter) adminAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n.RLock()
+		token := ""
+		if n.config.Admin != nil {
+			token = n.config.Admin.Token
+		}
+		n.RUnlock()
+
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			n.logger.Warn("unauthorized admin request",
+				zap.String("source", r.RemoteAddr),
+				zap.String("method", r.Method),
+				zap.String("path", r.URL.Path),
+			)
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSONError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (n *NameRouter) adminListRoutes(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, n.Routes())
+}
+
+func (n *NameRouter) adminGetRoute(w http.ResponseWriter, r *http.Request) {
+	nh, err := n.Route(mux.Vars(r)["name"])
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, nh)
+}
+
+func (n *NameRouter) adminCreateRoute(w http.ResponseWriter, r *http.Request) {
+	nh := &Namehost{}
+	if err := decodeBody(r, nh); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := n.AddRoute(nh); err != nil {
+		writeAdminError(w, err)
+		return
+	}
+
+	if nh.Name == "" {
+		nh.Name = nh.defaultName()
+	}
+	n.logger.Info("admin: created route",
+		zap.String("name", nh.Name),
+	)
+
+	created, err := n.Route(nh.Name)
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, created)
+}
+
+func (n *NameRouter) adminPatchRoute(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	body, err := readBody(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	err = n.UpdateRoute(name, func(nh *Namehost) error {
+		// Fields in the body replace those in the route, anything not in
+		// the body is left as it was
+		if err := decodeInto(body, nh); err != nil {
+			return &ValidationError{Msg: err.Error()}
+		}
+		if nh.Name != name {
+			return &ValidationError{Msg: "route name can't be changed"}
+		}
+		return nil
+	})
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+
+	n.logger.Info("admin: updated route",
+		zap.String("name", name),
+	)
+
+	updated, err := n.Route(name)
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, updated)
+}
+
+func (n *NameRouter) adminDeleteRoute(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if err := n.RemoveRoute(name); err != nil {
+		writeAdminError(w, err)
+		return
+	}
+
+	n.logger.Info("admin: deleted route",
+		zap.String("name", name),
+	)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func readBody(r *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxAdminBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request body: %w", err)
+	}
+	return body, nil
+}
+
+func decodeBody(r *http.Request, v interface{}) error {
+	body, err := readBody(r)
+	if err != nil {
+		return err
+	}
+	return decodeInto(body, v)
+}
+
+// decodeInto decodes a JSON or YAML request body into v, using the same
+// field names as the config file. Unknown fields are an error.
+func decodeInto(body []byte, v interface{}) error {
+	var root yaml.Node
+	if err := yaml.Unmarshal(body, &root); err != nil {
+		return fmt.Errorf("invalid request body: %w", err)
+	}
+
+	if errs := unknownFields("", documentContent(&root), reflect.TypeOf(v)); len(errs) > 0 {
+		return errs
+	}
+
+	if err := root.Decode(v); err != nil {
+		return fmt.Errorf("invalid request body: %w", err)
+	}
+
+	return nil
+}
+
+// writeJSON writes v as JSON. It is converted through YAML first, so that
+// the JSON uses the same field names as the config file.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	var out interface{}
+	data, err := yaml.Marshal(v)
+	if err == nil {
+		err = yaml.Unmarshal(data, &out)
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(out)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{
+		"error": err.Error(),
+	})
+}
+
+// writeAdminError writes an error from a route change with a matching
+// status code
+func writeAdminError(w http.ResponseWriter, err error) {
+	var vErrs ValidationErrors
+	var vErr *ValidationError
+	switch {
+	case errors.Is(err, ErrRouteNotFound):
+		writeJSONError(w, http.StatusNotFound, err)
+	case errors.Is(err, ErrRouteExists), errors.Is(err, ErrRouteReference):
+		writeJSONError(w, http.StatusConflict, err)
+	case errors.As(err, &vErrs), errors.As(err, &vErr):
+		writeJSONError(w, http.StatusBadRequest, err)
+	default:
+		writeJSONError(w, http.StatusInternalServerError, err)
+	}
+}
@@ -0,0 +1,84 @@
+package namerouter
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAdminAPI(t *testing.T) {
+	upstream := testUpstream(t, "ok")
+	n, proxy := testServer(t, testConfig(t, `middlewares: [namehost]
+admin:
+  addr: 127.0.0.1:0
+  token: test
+routes:
+  - name: app
+    internal: [app.test]
+    destination: `+upstream+`
+`))
+	admin := httptest.NewServer(n.adminRouter())
+	defer admin.Close()
+
+	send := func(method string, path string, token string, body string) (int, map[string]interface{}) {
+		t.Helper()
+		req, err := http.NewRequest(method, admin.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := admin.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		_ = json.Unmarshal(data, &out)
+		return resp.StatusCode, out
+	}
+
+	steps := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		body   string
+		status int
+	}{
+		{name: "no token", method: http.MethodGet, path: "/routes", status: http.StatusUnauthorized},
+		{name: "wrong token", method: http.MethodGet, path: "/routes", token: "nope", status: http.StatusUnauthorized},
+		{name: "list", method: http.MethodGet, path: "/routes", token: "test", status: http.StatusOK},
+		{name: "get", method: http.MethodGet, path: "/routes/app", token: "test", status: http.StatusOK},
+		{name: "get missing", method: http.MethodGet, path: "/routes/nope", token: "test", status: http.StatusNotFound},
+		{name: "create", method: http.MethodPost, path: "/routes", token: "test", body: `{"name": "new", "internal": ["new.test"], "destination": "` + upstream + `"}`, status: http.StatusCreated},
+		{name: "create existing", method: http.MethodPost, path: "/routes", token: "test", body: `{"name": "new", "internal": ["other.test"], "destination": "` + upstream + `"}`, status: http.StatusConflict},
+		{name: "create invalid", method: http.MethodPost, path: "/routes", token: "test", body: `{"name": "bad", "internal": ["bad.test"], "destination": "ftp://bad"}`, status: http.StatusBadRequest},
+		{name: "create duplicate host", method: http.MethodPost, path: "/routes", token: "test", body: `{"name": "dup", "internal": ["app.test"], "destination": "` + upstream + `"}`, status: http.StatusBadRequest},
+		{name: "patch", method: http.MethodPatch, path: "/routes/new", token: "test", body: `{"always404": true, "destination": ""}`, status: http.StatusOK},
+		{name: "patch rename", method: http.MethodPatch, path: "/routes/new", token: "test", body: `{"name": "renamed"}`, status: http.StatusBadRequest},
+		{name: "delete", method: http.MethodDelete, path: "/routes/app", token: "test", status: http.StatusNoContent},
+		{name: "delete missing", method: http.MethodDelete, path: "/routes/app", token: "test", status: http.StatusNotFound},
+	}
+	for _, step := range steps {
+		if status, body := send(step.method, step.path, step.token, step.body); status != step.status {
+			t.Fatalf("%s: status = %d %v, want %d", step.name, status, body, step.status)
+		}
+	}
+
+	// The changes are served straight away
+	if status, _ := get(t, proxy, "new.test", "/"); status != http.StatusNotFound {
+		t.Errorf("patched route status = %d, want %d", status, http.StatusNotFound)
+	}
+	if status, _ := get(t, proxy, "app.test", "/"); status != http.StatusBadRequest {
+		t.Errorf("deleted route status = %d, want %d", status, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,187 @@
+package namerouter
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+)
+
+// variantRequests counts the requests served by each weighted destination,
+// keyed by route and variant name
+var variantRequests = newMetric("namerouter_variant_requests")
+
+// WeightedDestination is one of several destinations a route splits its
+// traffic between, such as a stable and a canary version of a service
+type WeightedDestination struct {
+	// Name labels the variant in logs, metrics and the sticky cookie. It
+	// defaults to the destination's host.
+	Name            string `yaml:"name,omitempty"`
+	DestinationAddr string `yaml:"destination"`
+	// Weight is the share of requests sent to the destination, relative to
+	// the weights of the others
+	Weight int `yaml:"weight"`
+}
+
+// variant is a WeightedDestination with the proxy built from it
+type variant struct {
+	*WeightedDestination
+	name  string
+	proxy *httputil.ReverseProxy
+}
+
+func (d *WeightedDestination) variantName() string {
+	if d.Name != "" {
+		return d.Name
+	}
+	if u, err := url.Parse(d.DestinationAddr); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return d.DestinationAddr
+}
+
+func buildVariants(dests []*WeightedDestination) ([]*variant, error) {
+	variants := make([]*variant, 0, len(dests))
+	for _, d := range dests {
+		if d == nil {
+			continue
+		}
+		u, err := url.Parse(d.DestinationAddr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse URL for destination %s: %w", d.variantName(), err)
+		}
+		variants = append(variants, &variant{
+			WeightedDestination: d,
+			name:                d.variantName(),
+			proxy:               httputil.NewSingleHostReverseProxy(u),
+		})
+	}
+	return variants, nil
+}
+
+// stickyVariant returns the variant named by the route's sticky cookie, if
+// the request has one for a variant that still gets traffic
+func (nh *Namehost) stickyVariant(r *http.Request) *variant {
+	if nh.StickyCookie == "" {
+		return nil
+	}
+	c, err := r.Cookie(nh.StickyCookie)
+	if err != nil {
+		return nil
+	}
+	for _, v := range nh.variants {
+		if v.name == c.Value && v.Weight > 0 {
+			return v
+		}
+	}
+	return nil
+}
+
+// pickVariant chooses the variant to serve a request. Sticky requests keep
+// the variant from their cookie, others are picked at random by weight and
+// given a cookie if the route is sticky.
+func (nh *Namehost) pickVariant(w http.ResponseWriter, r *http.Request) *variant {
+	if v := nh.stickyVariant(r); v != nil {
+		return v
+	}
+
+	total := 0
+	for _, v := range nh.variants {
+		total += v.Weight
+	}
+
+	picked := nh.variants[0]
+	n := rand.IntN(total)
+	for _, v := range nh.variants {
+		if n < v.Weight {
+			picked = v
+			break
+		}
+		n -= v.Weight
+	}
+
+	if nh.StickyCookie != "" {
+		http.SetCookie(w, &http.Cookie{
+			Name:     nh.StickyCookie,
+			Value:    picked.name,
+			Path:     "/",
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+
+	return picked
+}
+
+// describeVariants describes how traffic is split between variants
+func (nh *Namehost) describeVariants() string {
+	total := 0
+	for _, v := range nh.variants {
+		total += v.Weight
+	}
+	parts := make([]string, 0, len(nh.variants))
+	for _, v := range nh.variants {
+		parts = append(parts, fmt.Sprintf("%s %s (%.4g%%)", v.name, v.DestinationAddr, float64(v.Weight)*100/float64(total)))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (nh *Namehost) validateDestinations() ValidationErrors {
+	errs := ValidationErrors{}
+
+	if nh.StickyCookie != "" && len(nh.Destinations) == 0 {
+		errs = append(errs, nh.pos.errorf("stickyCookie is only used with destinations"))
+	}
+	if len(nh.Destinations) == 0 {
+		return errs
+	}
+	if nh.DestinationAddr != "" {
+		errs = append(errs, nh.pos.errorf("route can't have both a destination and destinations"))
+	}
+
+	if nh.StickyCookie != "" {
+		if err := (&http.Cookie{Name: nh.StickyCookie}).Valid(); err != nil {
+			errs = append(errs, nh.pos.errorf("invalid stickyCookie %q: %s", nh.StickyCookie, err.Error()))
+		}
+	}
+
+	names := make(map[string]bool)
+	total := 0
+	for _, d := range nh.Destinations {
+		if d == nil {
+			errs = append(errs, nh.pos.errorf("empty destination"))
+			continue
+		}
+		name := d.variantName()
+		if names[name] {
+			errs = append(errs, nh.pos.errorf("destination name %q is used more than once", name))
+		}
+		names[name] = true
+
+		if nh.StickyCookie != "" {
+			if err := (&http.Cookie{Name: "variant", Value: name}).Valid(); err != nil {
+				errs = append(errs, nh.pos.errorf("destination name %q can't be used as a cookie value", name))
+			}
+		}
+
+		if d.Weight < 0 {
+			errs = append(errs, nh.pos.errorf("destination %s has a negative weight", name))
+		} else {
+			total += d.Weight
+		}
+
+		if d.DestinationAddr == "" {
+			errs = append(errs, nh.pos.errorf("destination %s is missing a destination", name))
+		} else if err := validateDestination(d.DestinationAddr); err != nil {
+			errs = append(errs, nh.pos.errorf("%s", err.Error()))
+		}
+	}
+
+	if total == 0 {
+		errs = append(errs, nh.pos.errorf("destinations have no weight"))
+	}
+
+	return errs
+}
@@ -0,0 +1,103 @@
+package namerouter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestPickVariant(t *testing.T) {
+	n := testNameRouter(t, `routes:
+  - name: app
+    internal: [app.local]
+    stickyCookie: variant
+    destinations:
+      - name: stable
+        destination: http://127.0.0.1:8080
+        weight: 3
+      - name: canary
+        destination: http://127.0.0.1:8081
+        weight: 1
+      - name: retired
+        destination: http://127.0.0.1:8082
+        weight: 0
+`)
+	nh := n.currentConfig().Routes[0]
+
+	counts := map[string]int{}
+	for range 4000 {
+		w := httptest.NewRecorder()
+		v := nh.pickVariant(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		counts[v.name]++
+		if cookie := w.Header().Get("Set-Cookie"); !strings.HasPrefix(cookie, "variant="+v.name+";") {
+			t.Fatalf("Set-Cookie = %q, want the variant %s", cookie, v.name)
+		}
+	}
+	if counts["retired"] != 0 {
+		t.Errorf("retired variant got %d requests, want none", counts["retired"])
+	}
+	// canary has a quarter of the weight
+	if c := counts["canary"]; c < 800 || c > 1200 {
+		t.Errorf("canary got %d of 4000 requests, want about 1000", c)
+	}
+
+	tests := map[string]string{
+		"variant=canary":  "canary",
+		"variant=stable":  "stable",
+		"variant=retired": "",
+		"variant=nope":    "",
+	}
+	for cookie, want := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Cookie", cookie)
+		got := ""
+		if v := nh.stickyVariant(r); v != nil {
+			got = v.name
+		}
+		if got != want {
+			t.Errorf("stickyVariant(%q) = %q, want %q", cookie, got, want)
+		}
+	}
+}
+
+func TestCanaryRoute(t *testing.T) {
+	_, s := testServer(t, testConfig(t, routerConfig(`    destinations:
+      - destination: `+pathUpstream(t, "stable")+`
+        weight: 1
+      - destination: `+pathUpstream(t, "canary")+`
+        weight: 0
+`)))
+	for range 10 {
+		if _, body := get(t, s, "app.test", "/a"); body != "stable /a" {
+			t.Fatalf("body = %q, want %q", body, "stable /a")
+		}
+	}
+}
+
+func TestDestinationsErrors(t *testing.T) {
+	dir := t.TempDir()
+	file := writeConfig(t, dir, "c.yaml", `routes:
+  - internal: [a.local]
+    destination: http://127.0.0.1:8080
+    stickyCookie: "bad cookie"
+    destinations:
+      - name: a
+        destination: http://127.0.0.1:8081
+        weight: -1
+      - name: a
+        destination: http://127.0.0.1:8082
+        weight: 0
+`)
+	want := []string{
+		"c.yaml:2: route can't have both a destination and destinations",
+		"c.yaml:2: invalid stickyCookie \"bad cookie\": http: invalid Cookie.Name",
+		"c.yaml:2: destination a has a negative weight",
+		"c.yaml:2: destination name \"a\" is used more than once",
+		"c.yaml:2: destinations have no weight",
+	}
+	if got := validationErrors(t, dir, file); !slices.Equal(got, want) {
+		t.Errorf("ValidateFile() =\n%q\nwant\n%q", got, want)
+	}
+}
@@ -0,0 +1,220 @@
+package namerouter
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// Client classes
+const (
+	clientsInternal = "internal"
+	clientsExternal = "external"
+)
+
+// Responses to external clients requesting an internal host
+const (
+	// InternalHostNotFound responds 404, as if the host didn't exist. It is
+	// the default.
+	InternalHostNotFound = "404"
+	// InternalHostForbidden responds 403
+	InternalHostForbidden = "403"
+	// InternalHostClose closes the connection without responding
+	InternalHostClose = "close"
+)
+
+// defaultInternalNetworks are the networks of internal clients when
+// internalNetworks isn't set: private, CGNAT, loopback and link-local ranges
+var defaultInternalNetworks = []string{
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"fc00::/7",
+	"::1/128",
+	"fe80::/10",
+}
+
+func (c *Config) internalNetworks() []string {
+	if c.InternalNetworks == nil {
+		return defaultInternalNetworks
+	}
+	return c.InternalNetworks
+}
+
+// parseNetworks parses a list of CIDRs. A plain IP address is a network
+// with just that address.
+func parseNetworks(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid network %q", cidr)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q: %w", cidr, err)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// Client describes the client that sent a request. namerouter stores it in
+// the request context before any middleware runs.
+type Client struct {
+	// IP is the client's address. For requests from a trusted proxy it is
+	// taken from the proxy's forwarded headers.
+	IP net.IP
+	// Internal is set when IP is on one of the internal networks
+	Internal bool
+	// RemoteIP is the address the request came from. It is a trusted proxy
+	// when it differs from IP.
+	RemoteIP net.IP
+
+	// hops are the addresses trusted proxies reported, from the client on,
+	// and via is the header they were taken from
+	hops []net.IP
+	via  string
+	// proto, host and port describe the original request, when a trusted
+	// proxy reported them
+	proto string
+	host  string
+	port  string
+}
+
+type clientCtxKeyType string
+
+var clientCtxKey clientCtxKeyType = "clientctxkey"
+
+// ClientFromContext returns the client stored in a request context
+func ClientFromContext(ctx context.Context) (*Client, bool) {
+	c, ok := ctx.Value(clientCtxKey).(*Client)
+	return c, ok
+}
+
+func (c *Client) class() string {
+	if c.Internal {
+		return clientsInternal
+	}
+	return clientsExternal
+}
+
+// isInternal reports whether a client IP is on an internal network
+func (n *NameRouter) isInternal(ip net.IP) bool {
+	return n.currentRoutes().isInternal(ip)
+}
+
+func (rt *routeTable) isInternal(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range rt.internalNetworks {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// client returns the client that sent a request, from the request context
+// if it has been stored there
+func (n *NameRouter) client(r *http.Request) *Client {
+	if c, ok := ClientFromContext(r.Context()); ok {
+		return c
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	c := &Client{RemoteIP: net.ParseIP(host)}
+	rt := n.routesFor(r)
+	rt.resolveClient(c, r.Header)
+	c.Internal = rt.isInternal(c.IP)
+	return c
+}
+
+// proxied reports whether the client IP came from a trusted proxy
+func (c *Client) proxied() bool {
+	return len(c.hops) > 0
+}
+
+// scheme returns the scheme of the original request, http or https
+func (c *Client) scheme(r *http.Request) string {
+	switch {
+	case c.proto != "":
+		return c.proto
+	case r.TLS != nil:
+		return "https"
+	default:
+		return "http"
+	}
+}
+
+// requestHost returns the Host of the original request
+func (c *Client) requestHost(r *http.Request) string {
+	if c.host != "" {
+		return c.host
+	}
+	return r.Host
+}
+
+// withClient stores the client that sent a request in its context
+func (n *NameRouter) withClient(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), clientCtxKey, n.client(r)))
+}
+
+// clientClass returns whether the client that sent a request is internal or
+// external
+func (n *NameRouter) clientClass(r *http.Request) string {
+	return n.client(r).class()
+}
+
+func (c *Config) internalHostResponse() string {
+	if c.InternalHostResponse == "" {
+		return InternalHostNotFound
+	}
+	return c.InternalHostResponse
+}
+
+// denyInternalHost responds to an external client requesting an internal
+// host
+func (n *NameRouter) denyInternalHost(w http.ResponseWriter, r *http.Request) {
+	response := n.routesFor(r).internalHostResponse
+
+	n.logger.Info("external client requested internal host",
+		zap.String("Host", r.Host),
+		zap.String("source", r.RemoteAddr),
+		zap.Stringer("client IP", n.client(r).IP),
+		zap.String("response", response),
+	)
+
+	switch response {
+	case InternalHostForbidden:
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	case InternalHostClose:
+		conn, _, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			// HTTP/2 connections can't be hijacked, aborting the handler
+			// resets the stream instead
+			panic(http.ErrAbortHandler)
+		}
+		conn.Close()
+	default:
+		http.NotFound(w, r)
+	}
+}
@@ -0,0 +1,190 @@
+package namerouter
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+// internalHostsConfig has an internal and an external host. Clients are
+// taken from X-Forwarded-For, so that tests can send requests as internal
+// and external clients.
+func internalHostsConfig(upstream string, extra string) string {
+	return `middlewares: [namehost]
+internalNetworks: [10.0.0.0/8]
+trustedProxies: [127.0.0.1/32]
+` + extra + `routes:
+  - internal: [int.test]
+    destination: ` + upstream + `
+  - external: [ext.test]
+    internal: [ext.local]
+    destination: ` + upstream + `
+`
+}
+
+func clientRequest(t *testing.T, s *httptest.Server, host string, clientIP string) (*http.Response, error) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, s.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Host = host
+	req.Header.Set("X-Forwarded-For", clientIP)
+	resp, err := s.Client().Do(req)
+	if err == nil {
+		resp.Body.Close()
+	}
+	return resp, err
+}
+
+func TestInternalHosts(t *testing.T) {
+	upstream := testUpstream(t, "ok")
+
+	tests := []struct {
+		name     string
+		response string
+		host     string
+		client   string
+		status   int
+	}{
+		{name: "internal client, internal host", host: "int.test", client: "10.1.2.3", status: http.StatusOK},
+		{name: "external client, internal host", host: "int.test", client: "203.0.113.9", status: http.StatusNotFound},
+		{name: "external client, external host", host: "ext.test", client: "203.0.113.9", status: http.StatusOK},
+		{name: "internal client, external host", host: "ext.test", client: "10.1.2.3", status: http.StatusOK},
+		{name: "external client, internal host of an external route", host: "ext.local", client: "203.0.113.9", status: http.StatusNotFound},
+		{name: "forbidden", response: InternalHostForbidden, host: "int.test", client: "203.0.113.9", status: http.StatusForbidden},
+		{name: "close", response: InternalHostClose, host: "int.test", client: "203.0.113.9"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			extra := ""
+			if tt.response != "" {
+				extra = "internalHostResponse: " + tt.response + "\n"
+			}
+			_, s := testServer(t, testConfig(t, internalHostsConfig(upstream, extra)))
+
+			resp, err := clientRequest(t, s, tt.host, tt.client)
+			if tt.status == 0 {
+				if err == nil {
+					t.Fatalf("status = %d, want the connection to be closed", resp.StatusCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.status {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+		})
+	}
+}
+
+func TestRequestsUseOneRouteTable(t *testing.T) {
+	upstream := testUpstream(t, "ok")
+	n, _ := testServer(t, testConfig(t, internalHostsConfig(upstream, "")))
+	old := n.currentRoutes()
+
+	// int.test becomes external
+	if err := n.Reload(testConfig(t, `middlewares: [namehost]
+internalNetworks: [10.0.0.0/8]
+trustedProxies: [127.0.0.1/32]
+routes:
+  - external: [int.test]
+    destination: `+upstream+`
+`)); err != nil {
+		t.Fatal(err)
+	}
+
+	// A request that arrived before the reload is still checked against the
+	// old routes, even where it looks up the route itself
+	r := httptest.NewRequest(http.MethodGet, "http://int.test/", nil)
+	r.RemoteAddr = "127.0.0.1:1234"
+	r.Header.Set("X-Forwarded-For", "203.0.113.9")
+	w := httptest.NewRecorder()
+	n.handler(w, n.withClient(withRoutes(r, old)))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status with the old routes = %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	w = httptest.NewRecorder()
+	n.handler(w, n.withClient(withRoutes(r, n.currentRoutes())))
+	if w.Code != http.StatusOK {
+		t.Errorf("status with the new routes = %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestIsInternal(t *testing.T) {
+	tests := []struct {
+		name     string
+		networks string
+		internal []string
+		external []string
+	}{
+		{
+			name:     "defaults",
+			internal: []string{"10.1.2.3", "172.16.0.1", "192.168.1.1", "100.64.0.1", "127.0.0.1", "169.254.1.1", "fd00::1", "::1", "fe80::1", "::ffff:10.1.2.3"},
+			external: []string{"8.8.8.8", "172.32.0.1", "100.128.0.1", "2001:db8::1"},
+		},
+		{
+			name:     "configured",
+			networks: "internalNetworks: [10.0.0.0/8, 203.0.113.9, 2001:db8::/32]\n",
+			internal: []string{"10.1.2.3", "203.0.113.9", "2001:db8::1"},
+			external: []string{"192.168.1.1", "127.0.0.1", "203.0.113.10", "::1"},
+		},
+		{
+			name:     "none",
+			networks: "internalNetworks: []\n",
+			external: []string{"10.1.2.3", "127.0.0.1", "::1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := testNameRouter(t, tt.networks+"routes: []\n")
+			for _, ip := range tt.internal {
+				if !n.isInternal(net.ParseIP(ip)) {
+					t.Errorf("%s is external, want internal", ip)
+				}
+			}
+			for _, ip := range tt.external {
+				if n.isInternal(net.ParseIP(ip)) {
+					t.Errorf("%s is internal, want external", ip)
+				}
+			}
+		})
+	}
+}
+
+func TestInternalNetworksReload(t *testing.T) {
+	n := testNameRouter(t, "internalNetworks: [10.0.0.0/8]\nroutes: []\n")
+	ip := net.ParseIP("192.168.1.1")
+	if n.isInternal(ip) {
+		t.Fatalf("%s is internal before the reload", ip)
+	}
+	if err := n.Reload(testConfig(t, "internalNetworks: [192.168.0.0/16]\nroutes: []\n")); err != nil {
+		t.Fatal(err)
+	}
+	if !n.isInternal(ip) {
+		t.Errorf("%s is external after the reload", ip)
+	}
+}
+
+func TestInternalNetworksErrors(t *testing.T) {
+	dir := t.TempDir()
+	file := writeConfig(t, dir, "c.yaml", `internalNetworks:
+  - 10.0.0.0/33
+  - nope
+routes: []
+`)
+	want := []string{
+		"c.yaml:1: invalid network \"10.0.0.0/33\": invalid CIDR address: 10.0.0.0/33",
+		"c.yaml:1: invalid network \"nope\"",
+	}
+	if got := validationErrors(t, dir, file); !slices.Equal(got, want) {
+		t.Errorf("ValidateFile() =\n%q\nwant\n%q", got, want)
+	}
+}
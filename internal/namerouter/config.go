@@ -0,0 +1,414 @@
+package namerouter
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"go.yaml.in/yaml/v3"
+	"golang.org/x/time/rate"
+)
+
+type Config struct {
+	RateLimits *RateLimits `yaml:"rateLimits"`
+	Routes     []*Namehost `yaml:"routes"`
+	DoSSL      bool        `yaml:"doSSL"`
+	Email      string      `yaml:"email"`
+	Debug      bool        `yaml:"debug"`
+	HTTPSPort  int         `yaml:"httpsPort"`
+	HTTPPort   int         `yaml:"httpPort"`
+	Include    []string    `yaml:"include"`
+	Admin      *Admin      `yaml:"admin"`
+	// RequireSNIMatch rejects HTTPS requests whose Host header is for a
+	// different host than the TLS server name
+	RequireSNIMatch bool `yaml:"requireSNIMatch"`
+	// ListenAddresses are the IP addresses the HTTP and HTTPS listeners are
+	// bound to. By default they listen on every IPv4 and IPv6 address.
+	ListenAddresses []string `yaml:"listenAddresses"`
+	// InternalNetworks are the networks of internal clients, as CIDRs. It
+	// defaults to private, CGNAT, loopback and link-local ranges.
+	InternalNetworks []string `yaml:"internalNetworks"`
+	// TrustedProxies are the networks of proxies, such as load balancers,
+	// whose forwarded headers are trusted to give the client IP
+	TrustedProxies []string `yaml:"trustedProxies"`
+	// TrustedProxyHeader is the header trusted proxies report the client IP
+	// in, X-Forwarded-For, X-Real-IP or Forwarded
+	TrustedProxyHeader string `yaml:"trustedProxyHeader"`
+	// InternalHostResponse is how external clients requesting an internal
+	// host are answered, 404, 403 or close
+	InternalHostResponse string `yaml:"internalHostResponse"`
+	// Middlewares is the global middleware chain, in the order requests
+	// pass through it. It defaults to the built in middleware.
+	Middlewares []string `yaml:"middlewares"`
+
+	// pos is where the config was loaded from, and keyLines has the line
+	// of each top level key. emptyRoutes are the positions of empty route
+	// entries, in the order they appear in Routes. They are all used for
+	// error reporting.
+	pos         position
+	keyLines    map[string]int
+	emptyRoutes []position
+}
+
+type RateLimits struct {
+	Internal *RateLimitConfig `yaml:"internal"`
+	External *RateLimitConfig `yaml:"external"`
+	// IPv4Prefix and IPv6Prefix are the prefix lengths clients are grouped
+	// by, so that every address of a network shares one limit. They default
+	// to 32, a single address, and 64.
+	IPv4Prefix int `yaml:"ipv4Prefix"`
+	IPv6Prefix int `yaml:"ipv6Prefix"`
+}
+type RateLimitConfig struct {
+	Rate  rate.Limit `yaml:"rate"`
+	Burst int        `yaml:"burst"`
+	pos   position
+}
+
+// Admin configures the admin API used to manage routes at runtime
+type Admin struct {
+	// Addr is the address the admin API listens on. The API is disabled
+	// when it is empty.
+	Addr string `yaml:"addr"`
+	// Token is the bearer token required on every admin API request
+	Token string `yaml:"token"`
+	// Persist writes changes made through the API back to the config files
+	Persist bool `yaml:"persist"`
+}
+
+func (c *Config) httpAddr() string {
+	if c.HTTPPort != 0 {
+		return fmt.Sprintf(":%d", c.HTTPPort)
+	}
+	return ":80"
+}
+
+func (c *Config) httpsAddr() string {
+	if c.HTTPSPort != 0 {
+		return fmt.Sprintf(":%d", c.HTTPSPort)
+	}
+	return ":443"
+}
+
+// listenAddrs returns the addresses to listen on for the listener at addr,
+// one for each of the listen addresses
+func (c *Config) listenAddrs(addr string) []string {
+	if len(c.ListenAddresses) == 0 {
+		return []string{addr}
+	}
+	_, port, _ := net.SplitHostPort(addr)
+	addrs := make([]string, 0, len(c.ListenAddresses))
+	for _, ip := range c.ListenAddresses {
+		addrs = append(addrs, net.JoinHostPort(strings.Trim(ip, "[]"), port))
+	}
+	return addrs
+}
+
+func (c *Config) adminAddr() string {
+	if c.Admin == nil {
+		return ""
+	}
+	return c.Admin.Addr
+}
+
+// position records where in a config file a value was defined
+type position struct {
+	file string
+	line int
+}
+
+func (p position) String() string {
+	if p.line == 0 {
+		return p.file
+	}
+	return fmt.Sprintf("%s:%d", p.file, p.line)
+}
+
+// LoadConfig reads and parses the config file at the given path, along with
+// any route files it includes. YAML, JSON and TOML files are supported.
+func LoadConfig(file string) (*Config, error) {
+	config, errs := loadConfig(file, false)
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("failed to parse config file:\n%w", errs)
+	}
+
+	return config, nil
+}
+
+// loadConfig loads the config file and its includes, collecting every
+// problem found along the way. When strict is set, unknown keys are also
+// reported.
+func loadConfig(file string, strict bool) (*Config, ValidationErrors) {
+	root, errs := readConfigNode(file)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	if strict {
+		errs = append(errs, unknownFields(file, documentContent(root), reflect.TypeOf(Config{}))...)
+	}
+
+	config, err := decodeConfig(file, root)
+	if err != nil {
+		errs = append(errs, yamlErrors(file, err)...)
+	}
+
+	includes, err := config.includeFiles()
+	if err != nil {
+		errs = append(errs, config.keyPos("include").errorf("%s", err.Error()))
+	}
+
+	for _, include := range includes {
+		routes, empty, includeErrs := loadRoutesFile(include, strict)
+		errs = append(errs, includeErrs...)
+		config.Routes = append(config.Routes, routes...)
+		config.emptyRoutes = append(config.emptyRoutes, empty...)
+	}
+
+	return config, errs
+}
+
+// readConfigNode reads and parses a config file. TOML files are detected by
+// their extension, everything else is parsed as YAML, which JSON is a
+// subset of.
+func readConfigNode(file string) (*yaml.Node, ValidationErrors) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, ValidationErrors{{File: file, Msg: err.Error()}}
+	}
+
+	var root yaml.Node
+	if strings.EqualFold(filepath.Ext(file), ".toml") {
+		var values map[string]interface{}
+		if err := toml.Unmarshal(data, &values); err != nil {
+			e := &ValidationError{File: file, Msg: err.Error()}
+			var decodeErr *toml.DecodeError
+			if errors.As(err, &decodeErr) {
+				e.Line, _ = decodeErr.Position()
+			}
+			return nil, ValidationErrors{e}
+		}
+		// TOML is converted to a YAML node so that it is decoded the same
+		// way, but line numbers are not kept.
+		if err := root.Encode(values); err != nil {
+			return nil, ValidationErrors{{File: file, Msg: err.Error()}}
+		}
+	} else if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, yamlErrors(file, err)
+	}
+
+	if errs := interpolate(file, &root); len(errs) > 0 {
+		return nil, errs
+	}
+
+	return &root, nil
+}
+
+// includePatterns returns the include globs, relative to the directory of
+// the main config file
+func (c *Config) includePatterns() []string {
+	dir := filepath.Dir(c.pos.file)
+	patterns := make([]string, 0, len(c.Include))
+	for _, pattern := range c.Include {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(dir, pattern)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns
+}
+
+// includeFiles returns the files matched by the include patterns. Files are
+// ordered by pattern, then by name, and each is only returned once.
+func (c *Config) includeFiles() ([]string, error) {
+	seen := map[string]bool{
+		filepath.Clean(c.pos.file): true,
+	}
+	files := []string{}
+
+	for _, pattern := range c.includePatterns() {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid include pattern %q: %w", pattern, err)
+		}
+		sort.Strings(matches)
+		for _, match := range matches {
+			if seen[filepath.Clean(match)] {
+				continue
+			}
+			seen[filepath.Clean(match)] = true
+			files = append(files, match)
+		}
+	}
+
+	return files, nil
+}
+
+// routesFile is the format of files pulled in with include. They may only
+// define routes, global settings belong in the main config file.
+type routesFile struct {
+	Routes []*Namehost `yaml:"routes"`
+}
+
+func loadRoutesFile(file string, strict bool) ([]*Namehost, []position, ValidationErrors) {
+	root, errs := readConfigNode(file)
+	if len(errs) > 0 {
+		return nil, nil, errs
+	}
+
+	globals := yamlFields(reflect.TypeOf(Config{}))
+	if doc := documentContent(root); doc != nil && doc.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(doc.Content); i += 2 {
+			key, value := doc.Content[i], doc.Content[i+1]
+			pos := position{file: file, line: key.Line}
+			switch _, global := globals[key.Value]; {
+			case key.Value == "routes":
+				if strict {
+					errs = append(errs, unknownFields(file, value, reflect.TypeOf(routesFile{}.Routes))...)
+				}
+			case global:
+				errs = append(errs, pos.errorf("%q can only be set in the main config file", key.Value))
+			case strict:
+				errs = append(errs, pos.errorf("unknown field %q", key.Value))
+			}
+		}
+	}
+
+	var rf routesFile
+	if err := root.Decode(&rf); err != nil {
+		errs = append(errs, yamlErrors(file, err)...)
+	}
+
+	for _, nh := range rf.Routes {
+		if nh != nil {
+			nh.pos.file = file
+		}
+	}
+
+	return rf.Routes, emptyItems(file, documentContent(root), "routes"), errs
+}
+
+// decodeConfig decodes a parsed config file. Positions of routes and rate
+// limits are recorded so that later errors can point back into the file.
+// On type errors the partially decoded config is returned along with the
+// error.
+func decodeConfig(file string, root *yaml.Node) (*Config, error) {
+	config := &Config{}
+	decodeErr := root.Decode(config)
+
+	config.pos = position{file: file}
+	config.keyLines = make(map[string]int)
+	if doc := documentContent(root); doc != nil && doc.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(doc.Content); i += 2 {
+			config.keyLines[doc.Content[i].Value] = doc.Content[i].Line
+		}
+	}
+
+	for _, nh := range config.Routes {
+		if nh != nil {
+			nh.pos.file = file
+		}
+	}
+	config.emptyRoutes = emptyItems(file, documentContent(root), "routes")
+	if config.RateLimits != nil {
+		for _, r := range []*RateLimitConfig{config.RateLimits.Internal, config.RateLimits.External} {
+			if r != nil {
+				r.pos.file = file
+			}
+		}
+	}
+
+	return config, decodeErr
+}
+
+// emptyItems returns the positions of the null items of a top level
+// sequence, which are decoded as nil
+func emptyItems(file string, doc *yaml.Node, key string) []position {
+	if doc == nil || doc.Kind != yaml.MappingNode {
+		return nil
+	}
+	var empty []position
+	for i := 0; i+1 < len(doc.Content); i += 2 {
+		if doc.Content[i].Value != key || doc.Content[i+1].Kind != yaml.SequenceNode {
+			continue
+		}
+		for _, item := range doc.Content[i+1].Content {
+			if item.ShortTag() == "!!null" {
+				empty = append(empty, position{file: file, line: item.Line})
+			}
+		}
+	}
+	return empty
+}
+
+func documentContent(node *yaml.Node) *yaml.Node {
+	if node.Kind == yaml.DocumentNode {
+		if len(node.Content) == 0 {
+			return nil
+		}
+		return node.Content[0]
+	}
+	return node
+}
+
+// keyPos returns the position of a top level config key
+func (c *Config) keyPos(key string) position {
+	return position{file: c.pos.file, line: c.keyLines[key]}
+}
+
+func (r *RateLimitConfig) UnmarshalYAML(value *yaml.Node) error {
+	type plain RateLimitConfig
+	if err := value.Decode((*plain)(r)); err != nil {
+		return err
+	}
+	r.pos.line = value.Line
+	return nil
+}
+
+// SetDefaults fills in any unset rate limits with their defaults, and names
+// any unnamed routes after their first host
+func (c *Config) SetDefaults() {
+	for _, nh := range c.Routes {
+		if nh != nil && nh.Name == "" {
+			nh.Name = nh.defaultName()
+		}
+	}
+
+	if c.RateLimits == nil {
+		c.RateLimits = &RateLimits{}
+	}
+
+	if c.RateLimits.IPv4Prefix == 0 {
+		c.RateLimits.IPv4Prefix = 8 * net.IPv4len
+	}
+	if c.RateLimits.IPv6Prefix == 0 {
+		c.RateLimits.IPv6Prefix = 64
+	}
+
+	if c.RateLimits.External == nil {
+		c.RateLimits.External = &RateLimitConfig{
+			Rate:  10,
+			Burst: 10,
+		}
+	}
+
+	if c.RateLimits.Internal == nil {
+		c.RateLimits.Internal = &RateLimitConfig{
+			Rate:  1000,
+			Burst: 1000,
+		}
+	}
+}
+
+func (r *RateLimitConfig) equal(o *RateLimitConfig) bool {
+	if r == nil || o == nil {
+		return r == o
+	}
+	return r.Rate == o.Rate && r.Burst == o.Burst
+}
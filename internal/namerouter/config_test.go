@@ -0,0 +1,174 @@
+package namerouter
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestIncludes(t *testing.T) {
+	dir := t.TempDir()
+	file := writeConfig(t, dir, "c.yaml", `include:
+  - "conf.d/*.yml"
+  - "extra.yml"
+  - "c.yaml"
+routes:
+  - internal: [main.local]
+    destination: http://127.0.0.1:8080
+`)
+	writeConfig(t, dir, "conf.d/b.yml", `routes:
+  - internal: [b.local]
+    destination: http://127.0.0.1:8082
+`)
+	writeConfig(t, dir, "conf.d/a.yml", `routes:
+  - internal: [a.local]
+    destination: http://127.0.0.1:8081
+`)
+	writeConfig(t, dir, "conf.d/ignored.yaml", `routes:
+  - internal: [ignored.local]
+    destination: http://127.0.0.1:8083
+`)
+	writeConfig(t, dir, "extra.yml", `routes:
+  - internal: [extra.local]
+    destination: http://127.0.0.1:8084
+`)
+
+	config, err := LoadConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var hosts []string
+	for _, nh := range config.Routes {
+		hosts = append(hosts, nh.InternalHosts...)
+	}
+	if want := []string{"main.local", "a.local", "b.local", "extra.local"}; !slices.Equal(hosts, want) {
+		t.Errorf("hosts = %v, want %v", hosts, want)
+	}
+	if got, want := config.Routes[1].pos.String(), filepath.Join(dir, "conf.d", "a.yml")+":2"; got != want {
+		t.Errorf("included route position = %s, want %s", got, want)
+	}
+}
+
+func TestIncludeErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		include string
+		want    []string
+	}{
+		{
+			name: "global setting",
+			include: `email: me@example.com
+routes: []
+`,
+			want: []string{"conf.d/r.yml:1: \"email\" can only be set in the main config file"},
+		},
+		{
+			name: "unknown field",
+			include: `rotues: []
+`,
+			want: []string{"conf.d/r.yml:1: unknown field \"rotues\""},
+		},
+		{
+			name: "route error",
+			include: `routes:
+  - internal: [r.local]
+    destination: ftp://127.0.0.1
+`,
+			want: []string{"conf.d/r.yml:2: destination \"ftp://127.0.0.1\" must be an http or https URL"},
+		},
+		{
+			name: "host defined in both files",
+			include: `routes:
+  - external: [main.local]
+    destination: http://127.0.0.1:8081
+`,
+			want: []string{
+				"conf.d/r.yml:2: route name \"main.local\" is already used at c.yaml:3",
+				"conf.d/r.yml:2: host \"main.local\" is already defined at c.yaml:3",
+			},
+		},
+		{
+			name: "empty route",
+			include: `routes:
+  -
+`,
+			want: []string{"conf.d/r.yml:2: empty route"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			file := writeConfig(t, dir, "c.yaml", `include: ["conf.d/*.yml"]
+routes:
+  - internal: [main.local]
+    destination: http://127.0.0.1:8080
+`)
+			writeConfig(t, dir, "conf.d/r.yml", tt.include)
+			if got := validationErrors(t, dir, file); !slices.Equal(got, tt.want) {
+				t.Errorf("ValidateFile() =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigFormats(t *testing.T) {
+	tests := map[string]string{
+		"c.yaml": `httpPort: 8080
+routes:
+  - internal: [app.local]
+    destination: http://127.0.0.1:8080
+`,
+		"c.json": `{
+  "httpPort": 8080,
+  "routes": [{"internal": ["app.local"], "destination": "http://127.0.0.1:8080"}]
+}
+`,
+		"c.toml": `httpPort = 8080
+
+[[routes]]
+internal = ["app.local"]
+destination = "http://127.0.0.1:8080"
+`,
+	}
+
+	for name, contents := range tests {
+		t.Run(name, func(t *testing.T) {
+			config, err := LoadConfig(writeConfig(t, t.TempDir(), name, contents))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.HTTPPort != 8080 {
+				t.Errorf("httpPort = %d, want 8080", config.HTTPPort)
+			}
+			if len(config.Routes) != 1 || config.Routes[0].DestinationAddr != "http://127.0.0.1:8080" ||
+				!slices.Equal(config.Routes[0].InternalHosts, []string{"app.local"}) {
+				t.Errorf("routes = %+v, want app.local to http://127.0.0.1:8080", config.Routes)
+			}
+		})
+	}
+
+	dir := t.TempDir()
+	file := writeConfig(t, dir, "c.toml", "httpPort = \"eighty\"\n")
+	if got := validationErrors(t, dir, file); len(got) == 0 {
+		t.Error("invalid TOML config was accepted")
+	}
+}
+
+func TestListenAddrs(t *testing.T) {
+	tests := []struct {
+		addresses []string
+		want      []string
+	}{
+		{want: []string{":80"}},
+		{addresses: []string{"0.0.0.0"}, want: []string{"0.0.0.0:80"}},
+		{addresses: []string{"127.0.0.1", "::1", "[2001:db8::1]"}, want: []string{"127.0.0.1:80", "[::1]:80", "[2001:db8::1]:80"}},
+	}
+	for _, tt := range tests {
+		c := &Config{ListenAddresses: tt.addresses}
+		if got := c.listenAddrs(":80"); !slices.Equal(got, tt.want) {
+			t.Errorf("listenAddrs(%v) = %v, want %v", tt.addresses, got, tt.want)
+		}
+	}
+}
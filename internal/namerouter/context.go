@@ -1,6 +1,7 @@
 package namerouter
 
 import (
+	"context"
 	"net/http"
 
 	"go.uber.org/zap"
@@ -10,6 +11,23 @@ type nameHostCtxKeyType string
 
 var nameHostCtxKey nameHostCtxKeyType = "namehostctxkey"
 
+var routesCtxKey nameHostCtxKeyType = "routesctxkey"
+
+// withRoutes stores the route table a request is served with in its context
+func withRoutes(r *http.Request, rt *routeTable) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), routesCtxKey, rt))
+}
+
+// routesFor returns the route table a request is served with. Every lookup
+// for a request uses the same table, so that a reload part way through the
+// request can't route it with one config and check it against another.
+func (n *NameRouter) routesFor(r *http.Request) *routeTable {
+	if rt, ok := r.Context().Value(routesCtxKey).(*routeTable); ok {
+		return rt
+	}
+	return n.currentRoutes()
+}
+
 func (n *NameRouter) getNamehost(req *http.Request) *Namehost {
 	// Check request context first
 	nh, ok := req.Context().Value(nameHostCtxKey).(*Namehost)
@@ -20,12 +38,11 @@ func (n *NameRouter) getNamehost(req *http.Request) *Namehost {
 		return nh
 	}
 
-	n.RLock()
-	nh, ok = n.nameHosts[req.Host]
-	n.RUnlock()
-	if ok {
+	nh, pattern := n.routesFor(req).match(normalizeHost(req.Host))
+	if nh != nil {
 		n.logger.Debug("got namehost from config map",
 			zap.String("host", req.Host),
+			zap.String("pattern", pattern),
 		)
 		return nh
 	}
@@ -0,0 +1,279 @@
+package namerouter
+
+import (
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// EffectiveConfig is the fully resolved configuration a router runs with,
+// after defaults are applied. Secrets such as the admin token, credentials
+// in destination URLs and header values are left out.
+type EffectiveConfig struct {
+	Listeners  EffectiveListeners `yaml:"listeners"`
+	TLS        EffectiveTLS       `yaml:"tls"`
+	RateLimits *RateLimits        `yaml:"rateLimits"`
+	// InternalNetworks are the networks of internal clients
+	InternalNetworks []string `yaml:"internalNetworks"`
+	// TrustedProxies are the networks of proxies the client IP is taken
+	// from forwarded headers for
+	TrustedProxies []string `yaml:"trustedProxies"`
+	// TrustedProxyHeader is the header trusted proxies report the client IP
+	// in
+	TrustedProxyHeader string `yaml:"trustedProxyHeader"`
+	// InternalHostResponse is how external clients requesting an internal
+	// host are answered
+	InternalHostResponse string `yaml:"internalHostResponse"`
+	// Middlewares is the global middleware chain, in order
+	Middlewares   []string                 `yaml:"middlewares"`
+	Routes        []*EffectiveRoute        `yaml:"routes"`
+	DefaultRoutes []*EffectiveDefaultRoute `yaml:"defaultRoutes"`
+}
+
+// EffectiveListeners are the addresses namerouter listens on. Disabled
+// listeners are empty.
+type EffectiveListeners struct {
+	HTTP   string `yaml:"http"`
+	HTTPS  string `yaml:"https"`
+	Health string `yaml:"health"`
+	Admin  string `yaml:"admin"`
+}
+
+type EffectiveTLS struct {
+	Enabled   bool   `yaml:"enabled"`
+	Email     string `yaml:"email"`
+	CertCache string `yaml:"certCache"`
+	// RequireSNIMatch is whether the Host must match the TLS server name
+	RequireSNIMatch bool `yaml:"requireSNIMatch"`
+	// CertificateHosts are the hosts certificates will be issued for. Wildcard
+	// and regex hosts cover every host they match.
+	CertificateHosts []string `yaml:"certificateHosts"`
+}
+
+type EffectiveRoute struct {
+	Name          string                 `yaml:"name"`
+	Source        string                 `yaml:"source,omitempty"`
+	Destination   string                 `yaml:"destination,omitempty"`
+	Always404     bool                   `yaml:"always404,omitempty"`
+	Redirect      *Redirect              `yaml:"redirect,omitempty"`
+	Static        *Static                `yaml:"static,omitempty"`
+	InternalHosts []string               `yaml:"internal,omitempty"`
+	ExternalHosts []string               `yaml:"external,omitempty"`
+	Match         []*MatchRule           `yaml:"match,omitempty"`
+	Paths         []*EffectivePath       `yaml:"paths,omitempty"`
+	Destinations  []*WeightedDestination `yaml:"destinations,omitempty"`
+	StickyCookie  string                 `yaml:"stickyCookie,omitempty"`
+	Mirror        []*Mirror              `yaml:"mirror,omitempty"`
+	HostHeader    string                 `yaml:"hostHeader,omitempty"`
+	Forwarded     string                 `yaml:"forwarded,omitempty"`
+	Rewrite       []*Rewrite             `yaml:"rewrite,omitempty"`
+	Headers       []*HeaderRule          `yaml:"headers,omitempty"`
+	Middlewares   []string               `yaml:"middlewares,omitempty"`
+}
+
+// EffectivePath is a route path, listed in the order paths are matched
+type EffectivePath struct {
+	Path        string `yaml:"path"`
+	Exact       bool   `yaml:"exact,omitempty"`
+	Destination string `yaml:"destination"`
+	StripPrefix bool   `yaml:"stripPrefix,omitempty"`
+}
+
+type EffectiveDefaultRoute struct {
+	Port        string `yaml:"port"`
+	Route       string `yaml:"route"`
+	Destination string `yaml:"destination,omitempty"`
+}
+
+// Effective returns the configuration a router using config and opts would
+// run with
+func Effective(config *Config, opts ...Option) (*EffectiveConfig, error) {
+	o, err := applyOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := newNameRouter(config, zap.NewNop(), o)
+	if err != nil {
+		return nil, err
+	}
+
+	return n.effectiveConfig(), nil
+}
+
+func (n *NameRouter) effectiveConfig() *EffectiveConfig {
+	n.RLock()
+	config := n.config
+	rt := n.routes
+	n.RUnlock()
+
+	e := &EffectiveConfig{
+		Listeners: EffectiveListeners{
+			HTTP:   strings.Join(config.listenAddrs(config.httpAddr()), ", "),
+			Health: n.opts.healthAddr,
+			Admin:  config.adminAddr(),
+		},
+		TLS: EffectiveTLS{
+			Enabled:          config.DoSSL,
+			Email:            config.Email,
+			RequireSNIMatch:  config.RequireSNIMatch,
+			CertificateHosts: []string{},
+		},
+		RateLimits:           config.RateLimits,
+		InternalNetworks:     config.internalNetworks(),
+		TrustedProxies:       append([]string{}, config.TrustedProxies...),
+		TrustedProxyHeader:   config.trustedProxyHeader(),
+		InternalHostResponse: config.internalHostResponse(),
+		Middlewares:          config.globalMiddlewares(),
+		Routes:               []*EffectiveRoute{},
+		DefaultRoutes:        []*EffectiveDefaultRoute{},
+	}
+
+	if n.opts.httpListener != nil {
+		e.Listeners.HTTP = n.opts.httpListener.Addr().String()
+	}
+
+	if config.DoSSL {
+		e.Listeners.HTTPS = strings.Join(config.listenAddrs(config.httpsAddr()), ", ")
+		if n.opts.httpsListener != nil {
+			e.Listeners.HTTPS = n.opts.httpsListener.Addr().String()
+		}
+		e.TLS.CertCache = n.opts.certCacheName()
+		for host := range rt.externalHosts {
+			e.TLS.CertificateHosts = append(e.TLS.CertificateHosts, host)
+		}
+		for _, p := range rt.wildcardHosts {
+			if p.external {
+				e.TLS.CertificateHosts = append(e.TLS.CertificateHosts, p.host)
+			}
+		}
+		for _, p := range rt.regexHosts {
+			if p.external {
+				e.TLS.CertificateHosts = append(e.TLS.CertificateHosts, p.host)
+			}
+		}
+		sort.Strings(e.TLS.CertificateHosts)
+	}
+
+	for _, nh := range config.Routes {
+		route := &EffectiveRoute{
+			Name:          nh.Name,
+			Source:        nh.pos.String(),
+			Destination:   redactURL(nh.DestinationAddr),
+			Always404:     nh.Always404,
+			InternalHosts: nh.InternalHosts,
+			ExternalHosts: nh.ExternalHosts,
+			StickyCookie:  nh.StickyCookie,
+			HostHeader:    nh.HostHeader,
+			Forwarded:     nh.Forwarded,
+			Rewrite:       nh.Rewrite,
+			Headers:       redactHeaders(nh.Headers),
+			Middlewares:   nh.Middlewares,
+		}
+		if route.HostHeader == "" && !nh.Always404 && nh.Redirect == nil && nh.Static == nil {
+			route.HostHeader = HostHeaderPassthrough
+		}
+		for _, v := range nh.variants {
+			route.Destinations = append(route.Destinations, &WeightedDestination{
+				Name:            v.name,
+				DestinationAddr: redactURL(v.DestinationAddr),
+				Weight:          v.Weight,
+			})
+		}
+		for _, m := range nh.mirrors {
+			percent := m.percent()
+			route.Mirror = append(route.Mirror, &Mirror{
+				DestinationAddr: redactURL(m.DestinationAddr),
+				Percent:         &percent,
+				MaxBodyBytes:    m.maxBody(),
+			})
+		}
+		if nh.Static != nil {
+			st := *nh.Static
+			st.Root = nh.staticRoot()
+			st.Index = st.indexFiles()
+			route.Static = &st
+		}
+		if nh.Redirect != nil {
+			rd := *nh.Redirect
+			rd.Status = rd.code()
+			route.Redirect = &rd
+		}
+		for _, rule := range nh.Match {
+			rc := *rule
+			rc.DestinationAddr = redactURL(rule.DestinationAddr)
+			rc.Headers = redactValues(rule.Headers)
+			rc.Cookies = redactValues(rule.Cookies)
+			route.Match = append(route.Match, &rc)
+		}
+		for _, p := range nh.paths {
+			route.Paths = append(route.Paths, &EffectivePath{
+				Path:        p.Path.Path,
+				Exact:       p.Exact,
+				Destination: redactURL(p.DestinationAddr),
+				StripPrefix: p.StripPrefix,
+			})
+		}
+		e.Routes = append(e.Routes, route)
+	}
+
+	for port, nh := range rt.defaultRoute {
+		e.DefaultRoutes = append(e.DefaultRoutes, &EffectiveDefaultRoute{
+			Port:        port,
+			Route:       nh.Name,
+			Destination: redactURL(nh.DestinationAddr),
+		})
+	}
+	sort.Slice(e.DefaultRoutes, func(i, j int) bool {
+		a, _ := strconv.Atoi(e.DefaultRoutes[i].Port)
+		b, _ := strconv.Atoi(e.DefaultRoutes[j].Port)
+		return a < b
+	})
+
+	return e
+}
+
+// redacted replaces values that can hold secrets, the same as passwords in
+// redacted URLs
+const redacted = "xxxxx"
+
+// redactHeaders hides the values header rules set, since they can hold
+// credentials for the destination
+func redactHeaders(rules []*HeaderRule) []*HeaderRule {
+	out := make([]*HeaderRule, 0, len(rules))
+	for _, rule := range rules {
+		rc := rule.copy()
+		for _, ops := range []*HeaderOps{rc.Request, rc.Response} {
+			if ops != nil {
+				ops.Set = redactValues(ops.Set)
+				ops.Append = redactValues(ops.Append)
+			}
+		}
+		out = append(out, rc)
+	}
+	return out
+}
+
+// redactValues hides the values of a map, keeping its keys
+func redactValues(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	out := make(map[string]string, len(m))
+	for k := range m {
+		out[k] = redacted
+	}
+	return out
+}
+
+// redactURL hides any password in a URL
+func redactURL(dest string) string {
+	u, err := url.Parse(dest)
+	if err != nil {
+		return dest
+	}
+	return u.Redacted()
+}
@@ -0,0 +1,438 @@
+package namerouter
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// ExplainRequest describes a simulated request for Explain
+type ExplainRequest struct {
+	Host     string
+	Path     string
+	ClientIP string
+	// LocalPort is the port the request arrives on. It defaults to the
+	// configured port for Scheme.
+	LocalPort int
+	// Scheme is http or https. It defaults to https when doSSL is on.
+	Scheme string
+	// Method defaults to GET
+	Method string
+	// Header has any request headers, including cookies
+	Header http.Header
+}
+
+// Explanation describes how a request would be routed
+type Explanation struct {
+	// Steps has a line for each decision made while routing the request,
+	// in the order they are made
+	Steps []string
+	// RateLimitClass is internal or external
+	RateLimitClass string
+	// Route is the route the request matched, if any
+	Route *Namehost
+	// Destination is where the request would be proxied to
+	Destination string
+	// Redirect is where the client would be redirected to
+	Redirect string
+	// Status is the status namerouter itself would respond with. It is 0
+	// when the request is proxied.
+	Status int
+	// Closed is set when the connection would be closed without a response
+	Closed bool
+}
+
+func (e *Explanation) step(format string, args ...interface{}) {
+	e.Steps = append(e.Steps, fmt.Sprintf(format, args...))
+}
+
+// Explain describes how a router using the given config would route a
+// request, without sending it anywhere
+func Explain(config *Config, req *ExplainRequest) (*Explanation, error) {
+	n, err := newNameRouter(config, zap.NewNop(), &options{})
+	if err != nil {
+		return nil, err
+	}
+
+	return n.explain(req)
+}
+
+// explain walks a request through the same decisions as the middleware
+// chain and handler
+func (n *NameRouter) explain(req *ExplainRequest) (*Explanation, error) {
+	r, err := n.explainHTTPRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Explanation{}
+
+	r = n.withClient(r)
+	client := n.client(r)
+	if client.proxied() {
+		e.step("client: %s is a trusted proxy, the client IP is %s from %s", client.RemoteIP, client.IP, client.via)
+	}
+	nh, pattern := n.currentRoutes().match(normalizeHost(r.Host))
+
+	for _, name := range n.config.globalMiddlewares() {
+		switch name {
+		case MiddlewareRateLimit:
+			limits := n.config.RateLimits.External
+			e.RateLimitClass = "external"
+			if client.Internal {
+				limits = n.config.RateLimits.Internal
+				e.RateLimitClass = "internal"
+			}
+			e.step("rate limit: client %s is %s, limited to %v requests/s with a burst of %d",
+				client.IP, e.RateLimitClass, float64(limits.Rate), limits.Burst)
+			if key := n.config.RateLimits.visitorKey(client.IP); key != client.IP.String()+"/32" && key != client.IP.String()+"/128" {
+				e.step("rate limit: the limit is shared by all clients in %s", key)
+			}
+
+		case MiddlewareNamehost:
+			if host := normalizeHost(r.Host); host != r.Host {
+				e.step("route: host %q is matched as %q", r.Host, host)
+			}
+			switch {
+			case nh != nil && pattern != "":
+				e.step("route: host %q matches %s of route %q", r.Host, pattern, nh.Name)
+			case nh != nil:
+				e.step("route: host %q matches route %q", r.Host, nh.Name)
+			default:
+				e.step("route: no route for host %q", r.Host)
+			}
+
+		case MiddlewareSourcePort:
+			port, _ := localPort(r)
+			if !isSourcePortRequest(r) {
+				e.step("source port: skipped, host is not an IP")
+				continue
+			}
+			if dr, ok := n.currentRoutes().defaultRoute[port]; ok && dr != nil {
+				e.step("source port: host is empty or an IP, using default route %q for port %s", dr.Name, port)
+				e.serveRoute(dr, r, n.headerVars(dr, r))
+				return e, nil
+			}
+			e.step("source port: no default route for port %s", port)
+
+		case MiddlewareHostHeader:
+			if r.Host == "" {
+				e.Status = http.StatusBadRequest
+				e.step("host header: missing, responding %d", e.Status)
+				return e, nil
+			}
+
+		case MiddlewareHTTPSRedirect:
+			// Only runs on the HTTP listener
+			if req.Scheme != "http" {
+				continue
+			}
+			if client.Internal {
+				e.step("https redirect: skipped, client is internal")
+				continue
+			}
+			if client.scheme(r) == "https" {
+				e.step("https redirect: skipped, the trusted proxy received the request over https")
+				continue
+			}
+			e.Status = http.StatusFound
+			e.Redirect = httpsLocation(r, client)
+			e.step("https redirect: external client on http, redirecting to %s", e.Redirect)
+			return e, nil
+
+		default:
+			e.step("middleware %s: custom middleware, assumed to pass the request on", name)
+		}
+	}
+
+	for range n.opts.middleware {
+		e.step("middleware: option middleware, assumed to pass the request on")
+	}
+
+	// handler
+	if nh == nil {
+		if dr, ok := n.currentRoutes().defaultRoute["80"]; ok && dr != nil {
+			e.step("handler: using default route %q", dr.Name)
+			e.serveRoute(dr, r, n.headerVars(dr, r))
+			return e, nil
+		}
+		e.Status = http.StatusBadRequest
+		e.step("handler: host not configured, responding %d", e.Status)
+		return e, nil
+	}
+
+	if !n.currentRoutes().isExternalHost(normalizeHost(r.Host)) && n.clientClass(r) == clientsExternal {
+		switch n.config.internalHostResponse() {
+		case InternalHostForbidden:
+			e.Status = http.StatusForbidden
+			e.step("handler: %q is an internal host and the client is external, responding %d", r.Host, e.Status)
+		case InternalHostClose:
+			e.Closed = true
+			e.step("handler: %q is an internal host and the client is external, closing the connection", r.Host)
+		default:
+			e.Status = http.StatusNotFound
+			e.step("handler: %q is an internal host and the client is external, responding %d", r.Host, e.Status)
+		}
+		return e, nil
+	}
+
+	e.serveRoute(nh, r, n.headerVars(nh, r))
+
+	return e, nil
+}
+
+// serveRoute explains what happens once a request is handed to a route
+func (e *Explanation) serveRoute(nh *Namehost, r *http.Request, vars headerVars) {
+	e.Route = nh
+
+	for _, name := range nh.Middlewares {
+		e.step("route middleware %s: custom middleware, assumed to pass the request on", name)
+	}
+
+	for i, rule := range nh.Headers {
+		if !rule.applies(vars) {
+			e.step("headers: rule %d skipped, it is for %s clients and the client is %s", i+1, rule.Clients, vars["client"])
+			continue
+		}
+		for _, change := range rule.Response.describe(vars) {
+			e.step("headers: response %s", change)
+		}
+	}
+
+	if nh.Always404 {
+		e.Status = http.StatusNotFound
+		e.step("handler: route is always404, responding %d", e.Status)
+		return
+	}
+
+	client, _ := ClientFromContext(r.Context())
+
+	if nh.Redirect != nil {
+		e.Redirect = nh.Redirect.location(r, client)
+		if e.Redirect == "" {
+			e.Status = http.StatusNotFound
+			e.step("handler: redirect preset %s doesn't apply to host %q, responding %d", nh.Redirect.Preset, r.Host, e.Status)
+			return
+		}
+		e.Status = nh.Redirect.code()
+		e.step("handler: route redirects to %s", e.Redirect)
+		return
+	}
+
+	if nh.Static != nil {
+		e.explainStatic(nh, r)
+		return
+	}
+
+	if uri, steps := nh.rewriteURI(r.URL.EscapedPath(), r.URL.RawQuery); len(steps) > 0 {
+		for _, s := range steps {
+			e.step("rewrite: rule %d rewrites the request to %q", s.rule+1, s.uri)
+		}
+		if last := nh.rewrites[steps[len(steps)-1].rule]; last.Last {
+			e.step("rewrite: rule %d is last, no more rules are applied", steps[len(steps)-1].rule+1)
+		}
+		u, err := url.ParseRequestURI(uri)
+		if err != nil {
+			e.step("rewrite: invalid rewritten request %q, it is proxied without rewriting", uri)
+		} else {
+			r = r.Clone(r.Context())
+			r.URL.Path, r.URL.RawPath, r.URL.RawQuery = u.Path, u.RawPath, u.RawQuery
+		}
+	}
+
+	dest, hasProxy := nh.DestinationAddr, nh.proxy != nil
+	if rule := nh.matchRule(r); rule != nil {
+		dest, hasProxy = rule.DestinationAddr, true
+		e.step("match: request matches rule %d", slices.Index(nh.Match, rule.MatchRule)+1)
+	} else if p := nh.matchPath(r.URL.Path); p != nil {
+		dest, hasProxy = p.DestinationAddr, true
+		kind := "prefix"
+		if p.Exact {
+			kind = "exact path"
+		}
+		e.step("path: %q matches %s %q", r.URL.Path, kind, p.Path.Path)
+		if p.StripPrefix {
+			e.step("path: stripping %q, upstream path is %q", p.Path.Path, stripPrefix(r, p.Path.Path).URL.Path)
+		}
+	} else if len(nh.variants) > 0 {
+		hasProxy = true
+		if v := nh.stickyVariant(r); v != nil {
+			dest = v.DestinationAddr
+			e.step("canary: cookie %s selects variant %s", nh.StickyCookie, v.name)
+		} else {
+			dest = nh.describeVariants()
+			e.step("canary: variant is picked by weight")
+		}
+	} else if len(nh.paths) > 0 || nh.matcher != nil {
+		if !hasProxy {
+			e.Status = http.StatusNotFound
+			e.step("match: request matches no rule or path and the route has no destination, responding %d", e.Status)
+			return
+		}
+		e.step("match: request matches no rule or path, using the route destination")
+	}
+
+	if !hasProxy {
+		e.Status = http.StatusNotImplemented
+		e.step("handler: proxy not configured for host, responding %d", e.Status)
+		return
+	}
+
+	fh := newForwarding(r, client).headers(nh.Forwarded)
+	proxied := client != nil && client.proxied()
+	for _, name := range forwardedHeaders {
+		switch {
+		case r.Header.Get(name) == "":
+		case !proxied:
+			e.step("forwarded: removing %s sent by the client", name)
+		case fh.Get(name) == "":
+			e.step("forwarded: passing on %s sent by the trusted proxy", name)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(fh)) {
+		e.step("forwarded: set %s: %q", name, fh.Get(name))
+	}
+	if host := nh.forward(r, dest, client).Host; host != r.Host {
+		e.step("forwarded: Host header is sent as %q", host)
+	}
+
+	for _, rule := range nh.Headers {
+		if rule.applies(vars) {
+			for _, change := range rule.Request.describe(vars) {
+				e.step("headers: request %s", change)
+			}
+		}
+	}
+
+	e.Destination = dest
+	e.step("handler: proxying to %s", e.Destination)
+	for _, m := range nh.mirrors {
+		e.step("mirror: %s", m.describe())
+	}
+}
+
+// explainStatic explains what a static route serves for a request
+func (e *Explanation) explainStatic(nh *Namehost, r *http.Request) {
+	root, err := os.OpenRoot(nh.staticRoot())
+	if err != nil {
+		e.Status = http.StatusInternalServerError
+		e.step("static: failed to open root %s: %s, responding %d", nh.staticRoot(), err, e.Status)
+		return
+	}
+	defer root.Close()
+
+	res := nh.resolveStatic(root, r.URL.Path)
+	switch {
+	case res.status != 0:
+		e.Status = res.status
+		e.step("static: nothing to serve for %q in %s, responding %d", r.URL.Path, nh.staticRoot(), e.Status)
+	case res.redirect != "":
+		e.Status = http.StatusMovedPermanently
+		e.Redirect = res.redirect
+		e.step("static: %q is a directory, redirecting to %s", r.URL.Path, e.Redirect)
+	case res.dir != "":
+		e.Status = http.StatusOK
+		e.step("static: serving a listing of %s", filepath.Join(nh.staticRoot(), res.dir))
+	default:
+		e.Status = http.StatusOK
+		e.step("static: serving %s", filepath.Join(nh.staticRoot(), res.file))
+	}
+}
+
+// explainHTTPRequest builds the http.Request the router would see for a
+// simulated request
+func (n *NameRouter) explainHTTPRequest(req *ExplainRequest) (*http.Request, error) {
+	switch req.Scheme {
+	case "":
+		req.Scheme = "http"
+		if n.config.DoSSL {
+			req.Scheme = "https"
+		}
+	case "https":
+		if !n.config.DoSSL {
+			return nil, fmt.Errorf("the https listener is not enabled, doSSL is off")
+		}
+	case "http":
+	default:
+		return nil, fmt.Errorf("scheme must be http or https, not %q", req.Scheme)
+	}
+
+	if req.Path == "" {
+		req.Path = "/"
+	}
+
+	if req.LocalPort == 0 {
+		req.LocalPort = 443
+		if n.config.HTTPSPort != 0 {
+			req.LocalPort = n.config.HTTPSPort
+		}
+		if req.Scheme == "http" {
+			req.LocalPort = 80
+			if n.config.HTTPPort != 0 {
+				req.LocalPort = n.config.HTTPPort
+			}
+		}
+	}
+
+	if net.ParseIP(req.ClientIP) == nil {
+		return nil, fmt.Errorf("invalid client IP %q", req.ClientIP)
+	}
+
+	req.Method = strings.ToUpper(req.Method)
+	if req.Method == "" {
+		req.Method = http.MethodGet
+	}
+
+	r, err := http.NewRequest(req.Method, req.Path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid request: %w", err)
+	}
+	for name, values := range req.Header {
+		for _, v := range values {
+			r.Header.Add(name, v)
+		}
+	}
+	r.Host = req.Host
+	r.RemoteAddr = net.JoinHostPort(req.ClientIP, "0")
+	r.RequestURI = req.Path
+	if req.Scheme == "https" {
+		r.TLS = &tls.ConnectionState{ServerName: normalizeHost(req.Host)}
+	}
+
+	localAddr := &net.TCPAddr{IP: net.IPv4zero, Port: req.LocalPort}
+	ctx := context.WithValue(r.Context(), http.LocalAddrContextKey, net.Addr(localAddr))
+
+	return r.WithContext(ctx), nil
+}
+
+// String formats the explanation for display
+func (e *Explanation) String() string {
+	out := ""
+	for i, s := range e.Steps {
+		out += strconv.Itoa(i+1) + ". " + s + "\n"
+	}
+
+	switch {
+	case e.Closed:
+		out += "result: connection closed"
+	case e.Destination != "":
+		out += "result: proxied to " + e.Destination
+	case e.Redirect != "":
+		out += fmt.Sprintf("result: %d redirect to %s", e.Status, e.Redirect)
+	default:
+		out += fmt.Sprintf("result: %d %s", e.Status, http.StatusText(e.Status))
+	}
+
+	return out
+}
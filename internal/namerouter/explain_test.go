@@ -0,0 +1,137 @@
+package namerouter
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestExplain(t *testing.T) {
+	config := `middlewares: [rateLimit, namehost, hostHeader, httpsRedirect]
+internalNetworks: [10.0.0.0/8]
+trustedProxies: [192.0.2.1/32]
+routes:
+  - name: app
+    internal: [app.local]
+    external: [app.example.com]
+    destination: http://10.0.0.1:8080
+    paths:
+      - path: /api
+        destination: http://10.0.0.2:8080
+  - name: private
+    internal: [private.local]
+    destination: http://10.0.0.3:8080
+  - name: moved
+    external: [old.example.com]
+    redirect:
+      url: https://new.example.com/
+      status: 301
+`
+
+	tests := []struct {
+		name        string
+		req         ExplainRequest
+		route       string
+		destination string
+		redirect    string
+		status      int
+		class       string
+		step        string
+	}{
+		{
+			name:        "internal client",
+			req:         ExplainRequest{Host: "app.local", ClientIP: "10.1.2.3"},
+			route:       "app",
+			destination: "http://10.0.0.1:8080",
+			class:       "internal",
+		},
+		{
+			name:        "path",
+			req:         ExplainRequest{Host: "APP.local:80", Path: "/api/users", ClientIP: "10.1.2.3"},
+			route:       "app",
+			destination: "http://10.0.0.2:8080",
+			class:       "internal",
+			step:        `route: host "APP.local:80" is matched as "app.local"`,
+		},
+		{
+			name:     "external client on http",
+			req:      ExplainRequest{Host: "app.example.com", Path: "/a", ClientIP: "203.0.113.9"},
+			redirect: "https://app.example.com/a",
+			status:   http.StatusFound,
+			class:    "external",
+		},
+		{
+			name: "external client on an internal host",
+			req: ExplainRequest{Host: "private.local", ClientIP: "192.0.2.1", Header: http.Header{
+				"X-Forwarded-For":   {"203.0.113.9"},
+				"X-Forwarded-Proto": {"https"},
+			}},
+			status: http.StatusNotFound,
+			class:  "external",
+			step:   "client: 192.0.2.1 is a trusted proxy, the client IP is 203.0.113.9",
+		},
+		{
+			name:     "redirect route",
+			req:      ExplainRequest{Host: "old.example.com", Path: "/a", ClientIP: "10.1.2.3"},
+			route:    "moved",
+			redirect: "https://new.example.com/",
+			status:   http.StatusMovedPermanently,
+			class:    "internal",
+		},
+		{
+			name:   "unknown host",
+			req:    ExplainRequest{Host: "nope.local", ClientIP: "10.1.2.3"},
+			status: http.StatusBadRequest,
+			class:  "internal",
+			step:   `route: no route for host "nope.local"`,
+		},
+		{
+			name:   "missing host",
+			req:    ExplainRequest{Host: "", ClientIP: "10.1.2.3"},
+			status: http.StatusBadRequest,
+			class:  "internal",
+			step:   "host header: missing, responding 400",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := Explain(testConfig(t, config), &tt.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			route := ""
+			if e.Route != nil {
+				route = e.Route.Name
+			}
+			if route != tt.route || e.Destination != tt.destination || e.Redirect != tt.redirect ||
+				e.Status != tt.status || e.RateLimitClass != tt.class {
+				t.Errorf("got route %q, destination %q, redirect %q, status %d, class %q\nwant route %q, destination %q, redirect %q, status %d, class %q\n%s",
+					route, e.Destination, e.Redirect, e.Status, e.RateLimitClass,
+					tt.route, tt.destination, tt.redirect, tt.status, tt.class, e)
+			}
+			if tt.step != "" && !strings.Contains(e.String(), tt.step) {
+				t.Errorf("explanation doesn't have step %q:\n%s", tt.step, e)
+			}
+		})
+	}
+}
+
+func TestExplainErrors(t *testing.T) {
+	config := `routes:
+  - internal: [app.local]
+    destination: http://10.0.0.1:8080
+`
+	tests := map[string]ExplainRequest{
+		"client ip":      {Host: "app.local", ClientIP: "nope"},
+		"scheme":         {Host: "app.local", ClientIP: "10.1.2.3", Scheme: "gopher"},
+		"https disabled": {Host: "app.local", ClientIP: "10.1.2.3", Scheme: "https"},
+	}
+	for name, req := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := Explain(testConfig(t, config), &req); err == nil {
+				t.Error("Explain() succeeded, want an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package namerouter
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Host header modes
+const (
+	// HostHeaderPassthrough sends the client's Host header to the
+	// destination. It is the default.
+	HostHeaderPassthrough = "passthrough"
+	// HostHeaderDestination sends the destination's host as the Host header
+	HostHeaderDestination = "destination"
+)
+
+// Forwarded header modes
+const (
+	// ForwardedXForwarded sets X-Forwarded-Proto, X-Forwarded-Host and
+	// X-Forwarded-Port
+	ForwardedXForwarded = "xForwarded"
+	// ForwardedRFC7239 sets the Forwarded header from RFC 7239
+	ForwardedRFC7239 = "rfc7239"
+	// ForwardedBoth sets both the X-Forwarded headers and Forwarded
+	ForwardedBoth = "both"
+)
+
+// forwardedHeaders are the headers clients can't set themselves, because
+// upstreams rely on them to describe the client
+var forwardedHeaders = []string{
+	"Forwarded",
+	"X-Forwarded-For",
+	"X-Forwarded-Host",
+	"X-Forwarded-Port",
+	"X-Forwarded-Proto",
+	"X-Real-Ip",
+}
+
+// forwarding describes the original request for the forwarded headers
+type forwarding struct {
+	// clientIP is the address the request came from, and hops are the
+	// addresses before it reported by trusted proxies
+	clientIP string
+	hops     []string
+	host     string
+	proto    string
+	port     string
+}
+
+func newForwarding(r *http.Request, client *Client) forwarding {
+	f := forwarding{
+		host:  r.Host,
+		proto: "http",
+	}
+	if r.TLS != nil {
+		f.proto = "https"
+	}
+	if ip, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		f.clientIP = ip
+	}
+	f.port, _ = localPort(r)
+	if client != nil {
+		for _, hop := range client.hops {
+			f.hops = append(f.hops, hop.String())
+		}
+		// A trusted proxy in front knows the original request better
+		f.host = client.requestHost(r)
+		if client.proto != "" {
+			f.proto = client.proto
+			f.port = client.port
+			if f.port == "" {
+				f.port = defaultPort(f.proto)
+			}
+		}
+	}
+	return f
+}
+
+// headers returns the forwarded headers set for a route's requests
+func (f forwarding) headers(mode string) http.Header {
+	h := http.Header{}
+	if len(f.hops) > 0 {
+		// The proxy appends clientIP
+		h.Set("X-Forwarded-For", strings.Join(f.hops, ", "))
+	}
+	if mode == ForwardedXForwarded || mode == ForwardedBoth {
+		h.Set("X-Forwarded-Proto", f.proto)
+		h.Set("X-Forwarded-Host", f.host)
+		if f.port != "" {
+			h.Set("X-Forwarded-Port", f.port)
+		}
+	}
+	if mode == ForwardedRFC7239 || mode == ForwardedBoth {
+		h.Set("Forwarded", f.forwarded())
+	}
+	return h
+}
+
+// forwarded returns the value of the Forwarded header
+func (f forwarding) forwarded() string {
+	var elems []string
+	for _, hop := range f.hops {
+		elems = append(elems, "for="+forwardedNode(hop))
+	}
+
+	var parts []string
+	if f.clientIP != "" {
+		parts = append(parts, "for="+forwardedNode(f.clientIP))
+	}
+	parts = append(parts, "host="+forwardedValue(f.host), "proto="+f.proto)
+	return strings.Join(append(elems, strings.Join(parts, ";")), ", ")
+}
+
+// forwardedNode returns an IP as a Forwarded node, with IPv6 addresses in
+// brackets
+func forwardedNode(ip string) string {
+	if strings.Contains(ip, ":") {
+		ip = "[" + ip + "]"
+	}
+	return forwardedValue(ip)
+}
+
+// forwardedValue quotes a Forwarded parameter value if it isn't a token
+func forwardedValue(v string) string {
+	if v != "" && !strings.ContainsAny(v, "[]:\"\\;,= ") {
+		return v
+	}
+	return fmt.Sprintf("%q", v)
+}
+
+// forward prepares a request to be proxied to dest. Forwarded headers sent
+// by untrusted clients are removed, the route's forwarded headers are set,
+// and the Host header is changed if the route asks for it. Forwarded headers
+// from trusted proxies are passed on unless the route sets them, and the hops
+// they report are kept in X-Forwarded-For, from the client on.
+func (nh *Namehost) forward(r *http.Request, dest string, client *Client) *http.Request {
+	f := newForwarding(r, client)
+
+	r2 := r.Clone(r.Context())
+	if client == nil || !client.proxied() {
+		for _, name := range forwardedHeaders {
+			r2.Header.Del(name)
+		}
+	}
+	for name, values := range f.headers(nh.Forwarded) {
+		r2.Header[name] = values
+	}
+	if nh.HostHeader == HostHeaderDestination {
+		if u, err := url.Parse(dest); err == nil {
+			r2.Host = u.Host
+		}
+	}
+	return r2
+}
+
+func (nh *Namehost) validateForwarding() ValidationErrors {
+	errs := ValidationErrors{}
+
+	switch nh.HostHeader {
+	case "", HostHeaderPassthrough, HostHeaderDestination:
+	default:
+		errs = append(errs, nh.pos.errorf("unknown hostHeader %q, must be %s or %s", nh.HostHeader, HostHeaderPassthrough, HostHeaderDestination))
+	}
+	switch nh.Forwarded {
+	case "", ForwardedXForwarded, ForwardedRFC7239, ForwardedBoth:
+	default:
+		errs = append(errs, nh.pos.errorf("unknown forwarded %q, must be %s, %s or %s", nh.Forwarded, ForwardedXForwarded, ForwardedRFC7239, ForwardedBoth))
+	}
+
+	return errs
+}
+
+// defaultPort returns the port a scheme uses when a URL doesn't have one
+func defaultPort(scheme string) string {
+	if scheme == "https" {
+		return "443"
+	}
+	return "80"
+}
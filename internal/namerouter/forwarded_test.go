@@ -0,0 +1,132 @@
+package namerouter
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// headerUpstream responds with the Host and forwarded headers it received,
+// one per line
+func headerUpstream(t *testing.T) string {
+	t.Helper()
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "Host: %s\n", r.Host)
+		for _, name := range forwardedHeaders {
+			if v := r.Header.Values(name); len(v) > 0 {
+				fmt.Fprintf(w, "%s: %s\n", name, strings.Join(v, ", "))
+			}
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s.URL
+}
+
+func TestForwardedHeaders(t *testing.T) {
+	upstream := headerUpstream(t)
+	spoofed := map[string]string{
+		"X-Forwarded-For":   "198.51.100.1",
+		"X-Forwarded-Host":  "evil.test",
+		"X-Forwarded-Proto": "https",
+		"X-Real-Ip":         "198.51.100.1",
+		"Forwarded":         "for=198.51.100.1",
+	}
+
+	tests := []struct {
+		name    string
+		trusted bool
+		route   string
+		headers map[string]string
+		want    string
+	}{
+		{
+			name:    "client headers are removed",
+			headers: spoofed,
+			want: `Host: app.test
+X-Forwarded-For: 127.0.0.1
+`,
+		},
+		{
+			name:    "client headers are replaced by the route's",
+			route:   "    forwarded: both\n    hostHeader: destination\n",
+			headers: spoofed,
+			want: `Host: ` + strings.TrimPrefix(upstream, "http://") + `
+Forwarded: for=127.0.0.1;host=app.test;proto=http
+X-Forwarded-For: 127.0.0.1
+X-Forwarded-Host: app.test
+X-Forwarded-Port: {port}
+X-Forwarded-Proto: http
+`,
+		},
+		{
+			name:    "trusted proxy headers are passed on",
+			trusted: true,
+			headers: map[string]string{
+				"X-Forwarded-For":   "203.0.113.9",
+				"X-Forwarded-Host":  "app.example.com",
+				"X-Forwarded-Proto": "https",
+			},
+			want: `Host: app.test
+X-Forwarded-For: 203.0.113.9, 127.0.0.1
+X-Forwarded-Host: app.example.com
+X-Forwarded-Proto: https
+`,
+		},
+		{
+			name:    "trusted proxy headers are replaced by the route's",
+			trusted: true,
+			route:   "    forwarded: rfc7239\n",
+			headers: map[string]string{
+				"X-Forwarded-For":   "203.0.113.9",
+				"X-Forwarded-Host":  "app.example.com",
+				"X-Forwarded-Proto": "https",
+				"Forwarded":         "for=198.51.100.1",
+			},
+			want: `Host: app.test
+Forwarded: for=203.0.113.9, for=127.0.0.1;host=app.example.com;proto=https
+X-Forwarded-For: 203.0.113.9, 127.0.0.1
+X-Forwarded-Host: app.example.com
+X-Forwarded-Proto: https
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trusted := ""
+			if tt.trusted {
+				trusted = "trustedProxies: [127.0.0.1/32]\n"
+			}
+			_, s := testServer(t, testConfig(t, `middlewares: [namehost]
+`+trusted+`routes:
+  - external: [app.test]
+    destination: `+upstream+`
+`+tt.route))
+
+			req, err := http.NewRequest(http.MethodGet, s.URL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Host = "app.test"
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			resp, err := s.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := strings.ReplaceAll(tt.want, "{port}", s.URL[strings.LastIndex(s.URL, ":")+1:])
+			if got := string(body); got != want {
+				t.Errorf("upstream got\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
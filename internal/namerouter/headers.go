@@ -0,0 +1,238 @@
+package namerouter
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// headerVar matches the placeholders in header values
+var headerVar = regexp.MustCompile(`\{([A-Za-z]+)\}`)
+
+// headerVarNames are the placeholders header values can use
+var headerVarNames = map[string]bool{
+	"clientIP": true,
+	"host":     true,
+	"scheme":   true,
+	"client":   true,
+	"route":    true,
+}
+
+// HeaderRule changes the headers of requests sent to a route's destination
+// and of responses sent back to the client
+type HeaderRule struct {
+	// Clients limits the rule to internal or external clients
+	Clients  string     `yaml:"clients,omitempty"`
+	Request  *HeaderOps `yaml:"request,omitempty"`
+	Response *HeaderOps `yaml:"response,omitempty"`
+}
+
+// HeaderOps are the changes made to a set of headers. Headers are removed
+// first, then set, then appended to. Values can use the placeholders
+// {clientIP}, {host}, {scheme}, {client} and {route}.
+type HeaderOps struct {
+	Set    map[string]string `yaml:"set,omitempty"`
+	Append map[string]string `yaml:"append,omitempty"`
+	Remove []string          `yaml:"remove,omitempty"`
+}
+
+// headerVars are the values of the placeholders for a request
+type headerVars map[string]string
+
+func (n *NameRouter) headerVars(nh *Namehost, r *http.Request) headerVars {
+	client := n.client(r)
+	ip := r.RemoteAddr
+	if client.IP != nil {
+		ip = client.IP.String()
+	}
+	return headerVars{
+		"clientIP": ip,
+		"host":     client.requestHost(r),
+		"scheme":   client.scheme(r),
+		"client":   n.clientClass(r),
+		"route":    nh.Name,
+	}
+}
+
+func (v headerVars) expand(value string) string {
+	return headerVar.ReplaceAllStringFunc(value, func(m string) string {
+		if val, ok := v[m[1:len(m)-1]]; ok {
+			return val
+		}
+		return m
+	})
+}
+
+// applies reports whether a rule applies to a request
+func (rule *HeaderRule) applies(vars headerVars) bool {
+	return rule.Clients == "" || rule.Clients == vars["client"]
+}
+
+// apply makes the changes to h
+func (ops *HeaderOps) apply(h http.Header, vars headerVars) {
+	if ops == nil {
+		return
+	}
+	for _, name := range ops.Remove {
+		h.Del(name)
+	}
+	for name, value := range ops.Set {
+		h.Set(name, vars.expand(value))
+	}
+	for name, value := range ops.Append {
+		h.Add(name, vars.expand(value))
+	}
+}
+
+// describe describes the changes made to a set of headers, in the order
+// they are made
+func (ops *HeaderOps) describe(vars headerVars) []string {
+	if ops == nil {
+		return nil
+	}
+	var out []string
+	for _, name := range ops.Remove {
+		out = append(out, fmt.Sprintf("remove %s", name))
+	}
+	for _, name := range sortedKeys(ops.Set) {
+		out = append(out, fmt.Sprintf("set %s: %q", name, vars.expand(ops.Set[name])))
+	}
+	for _, name := range sortedKeys(ops.Append) {
+		out = append(out, fmt.Sprintf("append %s: %q", name, vars.expand(ops.Append[name])))
+	}
+	return out
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// requestHeaders applies the route's request header rules to a request
+// before it is proxied
+func (nh *Namehost) requestHeaders(r *http.Request, vars headerVars) {
+	for _, rule := range nh.Headers {
+		if rule.applies(vars) {
+			rule.Request.apply(r.Header, vars)
+		}
+	}
+}
+
+// headerWriter applies a route's response header rules just before the
+// response headers are written
+type headerWriter struct {
+	http.ResponseWriter
+	nh          *Namehost
+	vars        headerVars
+	wroteHeader bool
+}
+
+func (hw *headerWriter) WriteHeader(status int) {
+	// Informational responses other than 101 are followed by the real one
+	if !hw.wroteHeader && (status >= 200 || status == http.StatusSwitchingProtocols) {
+		hw.wroteHeader = true
+		for _, rule := range hw.nh.Headers {
+			if rule.applies(hw.vars) {
+				rule.Response.apply(hw.Header(), hw.vars)
+			}
+		}
+	}
+	hw.ResponseWriter.WriteHeader(status)
+}
+
+func (hw *headerWriter) Write(b []byte) (int, error) {
+	if !hw.wroteHeader {
+		hw.WriteHeader(http.StatusOK)
+	}
+	return hw.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, so
+// flushing and hijacking still work for proxied responses
+func (hw *headerWriter) Unwrap() http.ResponseWriter {
+	return hw.ResponseWriter
+}
+
+func (nh *Namehost) validateHeaders() ValidationErrors {
+	errs := ValidationErrors{}
+
+	for i, rule := range nh.Headers {
+		if rule == nil {
+			errs = append(errs, nh.pos.errorf("empty header rule"))
+			continue
+		}
+		switch rule.Clients {
+		case "", clientsInternal, clientsExternal:
+		default:
+			errs = append(errs, nh.pos.errorf("header rule %d clients %q must be %s or %s", i+1, rule.Clients, clientsInternal, clientsExternal))
+		}
+		if rule.Request == nil && rule.Response == nil {
+			errs = append(errs, nh.pos.errorf("header rule %d has no request or response changes", i+1))
+		}
+		if rule.Request != nil && (nh.Always404 || nh.Redirect != nil || nh.Static != nil) {
+			errs = append(errs, nh.pos.errorf("header rule %d changes request headers, but the route doesn't proxy requests", i+1))
+		}
+		errs = append(errs, rule.Request.validate(nh, i, "request")...)
+		errs = append(errs, rule.Response.validate(nh, i, "response")...)
+	}
+
+	return errs
+}
+
+func (ops *HeaderOps) validate(nh *Namehost, i int, kind string) ValidationErrors {
+	errs := ValidationErrors{}
+	if ops == nil {
+		return errs
+	}
+
+	for _, name := range ops.Remove {
+		if !httpguts.ValidHeaderFieldName(name) {
+			errs = append(errs, nh.pos.errorf("header rule %d: invalid %s header name %q", i+1, kind, name))
+		}
+	}
+	for _, values := range []map[string]string{ops.Set, ops.Append} {
+		for _, name := range sortedKeys(values) {
+			if !httpguts.ValidHeaderFieldName(name) {
+				errs = append(errs, nh.pos.errorf("header rule %d: invalid %s header name %q", i+1, kind, name))
+			}
+			value := values[name]
+			if !httpguts.ValidHeaderFieldValue(value) {
+				errs = append(errs, nh.pos.errorf("header rule %d: invalid value for %s header %s", i+1, kind, name))
+			}
+			for _, m := range headerVar.FindAllStringSubmatch(value, -1) {
+				if !headerVarNames[m[1]] {
+					errs = append(errs, nh.pos.errorf("header rule %d: unknown placeholder %s in %s header %s", i+1, m[0], kind, name))
+				}
+			}
+		}
+	}
+
+	return errs
+}
+
+func (rule *HeaderRule) copy() *HeaderRule {
+	rc := *rule
+	rc.Request = rule.Request.copy()
+	rc.Response = rule.Response.copy()
+	return &rc
+}
+
+func (ops *HeaderOps) copy() *HeaderOps {
+	if ops == nil {
+		return nil
+	}
+	return &HeaderOps{
+		Set:    maps.Clone(ops.Set),
+		Append: maps.Clone(ops.Append),
+		Remove: slices.Clone(ops.Remove),
+	}
+}
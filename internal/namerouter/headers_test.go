@@ -0,0 +1,137 @@
+package namerouter
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestHeaderRules(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "upstream")
+		for _, name := range []string{"X-Client-Ip", "X-Route", "X-Secret", "X-Tag", "X-External"} {
+			fmt.Fprintf(w, "%s=%q\n", name, r.Header.Values(name))
+		}
+	}))
+	defer upstream.Close()
+
+	_, s := testServer(t, testConfig(t, `middlewares: [namehost]
+trustedProxies: [127.0.0.1/32]
+routes:
+  - name: app
+    external: [app.test]
+    destination: `+upstream.URL+`
+    headers:
+      - request:
+          remove: [X-Secret]
+          set:
+            X-Client-Ip: "{clientIP}"
+            X-Route: "{route} via {scheme}://{host}"
+          append:
+            X-Tag: "{client}"
+        response:
+          remove: [Server]
+          set:
+            X-Served-By: "{route}"
+      - clients: external
+        request:
+          set:
+            X-External: "yes"
+`))
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    string
+	}{
+		{
+			name:    "internal client",
+			headers: map[string]string{"X-Secret": "s3cret", "X-Tag": "sent"},
+			want: `X-Client-Ip=["127.0.0.1"]
+X-Route=["app via http://app.test"]
+X-Secret=[]
+X-Tag=["sent" "internal"]
+X-External=[]
+`,
+		},
+		{
+			name: "external client through a trusted proxy",
+			headers: map[string]string{
+				"X-Forwarded-For":   "203.0.113.9",
+				"X-Forwarded-Proto": "https",
+				"X-Forwarded-Host":  "app.example.com",
+			},
+			want: `X-Client-Ip=["203.0.113.9"]
+X-Route=["app via https://app.example.com"]
+X-Secret=[]
+X-Tag=["external"]
+X-External=["yes"]
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, s.URL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Host = "app.test"
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			resp, err := s.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(body) != tt.want {
+				t.Errorf("upstream got\n%s\nwant\n%s", body, tt.want)
+			}
+			if got := resp.Header.Get("X-Served-By"); got != "app" {
+				t.Errorf("X-Served-By = %q, want %q", got, "app")
+			}
+			if got := resp.Header.Values("Server"); len(got) != 0 {
+				t.Errorf("Server = %q, want it removed", got)
+			}
+		})
+	}
+}
+
+func TestHeaderRuleErrors(t *testing.T) {
+	dir := t.TempDir()
+	file := writeConfig(t, dir, "c.yaml", `routes:
+  - internal: [a.local]
+    destination: http://127.0.0.1:8080
+    headers:
+      - clients: everyone
+        request:
+          set:
+            "Bad Header": x
+            X-Id: "{requestID}"
+      - {}
+  - internal: [b.local]
+    always404: true
+    headers:
+      - request:
+          remove: [X-A]
+`)
+	want := []string{
+		"c.yaml:2: header rule 1 clients \"everyone\" must be internal or external",
+		"c.yaml:2: header rule 1: invalid request header name \"Bad Header\"",
+		"c.yaml:2: header rule 1: unknown placeholder {requestID} in request header X-Id",
+		"c.yaml:2: header rule 2 has no request or response changes",
+		"c.yaml:11: header rule 1 changes request headers, but the route doesn't proxy requests",
+	}
+	if got := validationErrors(t, dir, file); !slices.Equal(got, want) {
+		t.Errorf("ValidateFile() =\n%q\nwant\n%q", got, want)
+	}
+}
@@ -0,0 +1,180 @@
+package namerouter
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// normalizeHost puts a request host into the form hosts are matched in. The
+// port and any trailing dot are removed, and names are lowercased, with
+// international names converted to punycode.
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.Trim(host, "[]"), ".")
+	host = strings.ToLower(host)
+
+	for i := 0; i < len(host); i++ {
+		if host[i] >= 0x80 {
+			if ascii, err := idna.Lookup.ToASCII(host); err == nil {
+				host = ascii
+			}
+			break
+		}
+	}
+
+	return host
+}
+
+// normalizeConfigHost normalizes a configured exact or wildcard host the
+// same way as request hosts. Regex hosts are left as they are.
+func normalizeConfigHost(host string) string {
+	switch {
+	case host == "default" || isRegexHost(host):
+		return host
+	case isWildcardHost(host):
+		return "*." + normalizeHost(strings.TrimPrefix(host, "*."))
+	}
+	return normalizeHost(host)
+}
+
+// hostPattern is a wildcard or regex host
+type hostPattern struct {
+	// host is the host as written in the config
+	host     string
+	re       *regexp.Regexp
+	nh       *Namehost
+	external bool
+}
+
+// isWildcardHost reports whether host is a wildcard such as *.example.com,
+// which matches any single label in place of the *
+func isWildcardHost(host string) bool {
+	return strings.HasPrefix(host, "*.")
+}
+
+// isRegexHost reports whether host is a regular expression, which is
+// written with a leading ~
+func isRegexHost(host string) bool {
+	return strings.HasPrefix(host, "~")
+}
+
+// compileHostRegex compiles a regex host. The expression must match the
+// whole host.
+func compileHostRegex(host string) (*regexp.Regexp, error) {
+	expr := strings.TrimPrefix(host, "~")
+	if _, err := regexp.Compile(expr); err != nil {
+		return nil, fmt.Errorf("invalid host regex %q: %w", host, err)
+	}
+	return regexp.Compile("^(?:" + expr + ")$")
+}
+
+// validateHost checks that a configured host is a valid exact, wildcard or
+// regex host
+func validateHost(host string) error {
+	switch {
+	case isRegexHost(host):
+		if strings.TrimPrefix(host, "~") == "" {
+			return fmt.Errorf("empty host regex")
+		}
+		_, err := compileHostRegex(host)
+		return err
+	case isWildcardHost(host):
+		rest := strings.TrimPrefix(host, "*.")
+		if rest == "" || strings.Contains(rest, "*") {
+			return fmt.Errorf("invalid wildcard host %q, only a leading *. is allowed", host)
+		}
+	case strings.Contains(host, "*"):
+		return fmt.Errorf("invalid wildcard host %q, only a leading *. is allowed", host)
+	}
+	return nil
+}
+
+// match returns the route for a request host, along with the wildcard or
+// regex host it matched, which is empty for an exact match. Exact hosts are
+// checked first, then wildcards, then regexes in config order.
+func (rt *routeTable) match(host string) (*Namehost, string) {
+	if nh, ok := rt.nameHosts[host]; ok {
+		return nh, ""
+	}
+	if p := rt.matchPattern(host); p != nil {
+		return p.nh, p.host
+	}
+	return nil, ""
+}
+
+func (rt *routeTable) matchPattern(host string) *hostPattern {
+	if _, parent, ok := strings.Cut(host, "."); ok {
+		if p, ok := rt.wildcardHosts[parent]; ok {
+			return p
+		}
+	}
+	for _, p := range rt.regexHosts {
+		if p.re.MatchString(host) {
+			return p
+		}
+	}
+	return nil
+}
+
+// isExternalHost reports whether host is routed to by an external host. Only
+// external hosts get certificates, and internal hosts are only served to
+// internal clients.
+func (rt *routeTable) isExternalHost(host string) bool {
+	if _, ok := rt.externalHosts[host]; ok {
+		return true
+	}
+	if _, ok := rt.nameHosts[host]; ok {
+		// An internal exact host takes precedence over any pattern
+		return false
+	}
+	if p := rt.matchPattern(host); p != nil {
+		return p.external
+	}
+	return false
+}
+
+// hosts returns every configured host other than default, keyed by the host
+// as written in the config
+func (rt *routeTable) hosts() map[string]*Namehost {
+	hosts := make(map[string]*Namehost, len(rt.nameHosts)+len(rt.wildcardHosts)+len(rt.regexHosts))
+	for host, nh := range rt.nameHosts {
+		hosts[host] = nh
+	}
+	for _, p := range rt.wildcardHosts {
+		hosts[p.host] = p.nh
+	}
+	for _, p := range rt.regexHosts {
+		hosts[p.host] = p.nh
+	}
+	return hosts
+}
+
+// overlaps describes exact and wildcard hosts that are also matched by a
+// wildcard or regex host of another route. They are not errors, as the
+// precedence rules decide which route is used, but are often a mistake.
+func (rt *routeTable) overlaps() []string {
+	msgs := []string{}
+	for host, nh := range rt.nameHosts {
+		if p := rt.matchPattern(host); p != nil && p.nh != nh {
+			msgs = append(msgs, fmt.Sprintf("host %s of route %s is also matched by %s of route %s, the exact host takes precedence",
+				host, nh.Name, p.host, p.nh.Name))
+		}
+	}
+	for _, w := range rt.wildcardHosts {
+		for _, p := range rt.regexHosts {
+			if p.nh != w.nh && p.re.MatchString("x"+strings.TrimPrefix(w.host, "*")) {
+				msgs = append(msgs, fmt.Sprintf("hosts matching %s of route %s may also match %s of route %s, the wildcard takes precedence",
+					w.host, w.nh.Name, p.host, p.nh.Name))
+			}
+		}
+	}
+	sort.Strings(msgs)
+	return msgs
+}
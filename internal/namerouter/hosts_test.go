@@ -0,0 +1,158 @@
+package namerouter
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestMatchHost(t *testing.T) {
+	n := testNameRouter(t, `
+routes:
+  - name: exact
+    internal: [api.example.com]
+    destination: http://127.0.0.1:1
+  - name: wildcard
+    internal: ["*.example.com"]
+    destination: http://127.0.0.1:2
+  - name: regex
+    internal: ["~[a-z]+\\.example\\.(com|net)"]
+    destination: http://127.0.0.1:3
+  - name: later-regex
+    internal: ["~.*\\.net"]
+    destination: http://127.0.0.1:4
+`)
+	rt := n.currentRoutes()
+
+	tests := []struct {
+		host    string
+		route   string
+		pattern string
+	}{
+		{host: "api.example.com", route: "exact"},
+		{host: "www.example.com", route: "wildcard", pattern: "*.example.com"},
+		{host: "a.b.example.com", route: ""},
+		{host: "example.com", route: ""},
+		{host: "www.example.net", route: "regex", pattern: "~[a-z]+\\.example\\.(com|net)"},
+		{host: "www1.example.net", route: "later-regex", pattern: "~.*\\.net"},
+		{host: "xwww.example.net.evil.com", route: ""},
+		{host: "other.org", route: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			nh, pattern := rt.match(tt.host)
+			route := ""
+			if nh != nil {
+				route = nh.Name
+			}
+			if route != tt.route || pattern != tt.pattern {
+				t.Errorf("match(%q) = %q, %q, want %q, %q", tt.host, route, pattern, tt.route, tt.pattern)
+			}
+		})
+	}
+}
+
+func TestNormalizeHost(t *testing.T) {
+	tests := map[string]string{
+		"Example.COM":       "example.com",
+		"example.com:8443":  "example.com",
+		"example.com.":      "example.com",
+		"[2001:db8::1]:443": "2001:db8::1",
+		"bücher.example":    "xn--bcher-kva.example",
+	}
+	for in, want := range tests {
+		if got := normalizeHost(in); got != want {
+			t.Errorf("normalizeHost(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestNormalizedHostLookup(t *testing.T) {
+	upstream := testUpstream(t, "ok")
+	_, s := testServer(t, testConfig(t, `middlewares: [namehost]
+routes:
+  - internal: [app1.local]
+    destination: `+upstream+`
+`))
+
+	for _, host := range []string{"app1.local", "app1.local:8080", "APP1.local", "app1.local."} {
+		t.Run(host, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, s.URL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Host = host
+			resp, err := s.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+			}
+		})
+	}
+}
+
+func TestHTTPSLocation(t *testing.T) {
+	tests := []struct {
+		url    string
+		client Client
+		want   string
+	}{
+		{url: "http://app.example.com/a?b=c", want: "https://app.example.com/a?b=c"},
+		{url: "http://app.example.com:8080/a", want: "https://app.example.com/a"},
+		{url: "http://[2001:db8::1]:8080/a", want: "https://[2001:db8::1]/a"},
+		{url: "http://10.0.0.5:8080/a", client: Client{host: "app.example.com:80"}, want: "https://app.example.com/a"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, tt.url, nil)
+		if got := httpsLocation(r, &tt.client); got != tt.want {
+			t.Errorf("httpsLocation(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestHostErrors(t *testing.T) {
+	dir := t.TempDir()
+	file := writeConfig(t, dir, "c.yaml", `routes:
+  - internal: ["*.example.com", "~[a-z+\\.example\\.net", "a.*.example.com"]
+    destination: http://127.0.0.1:8080
+  - external: ["*.EXAMPLE.com"]
+    destination: http://127.0.0.1:8081
+`)
+	want := []string{
+		"c.yaml:2: invalid host regex \"~[a-z+\\\\.example\\\\.net\": error parsing regexp: missing closing ]: `[a-z+\\.example\\.net`",
+		"c.yaml:2: invalid wildcard host \"a.*.example.com\", only a leading *. is allowed",
+		"c.yaml:4: host \"*.EXAMPLE.com\" is already defined at c.yaml:2",
+	}
+	if got := validationErrors(t, dir, file); !slices.Equal(got, want) {
+		t.Errorf("ValidateFile() =\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestHostPolicy(t *testing.T) {
+	n := testNameRouter(t, `routes:
+  - external: [app.example.com, "*.example.net", "~api[0-9]\\.example\\.org"]
+    internal: [internal.example.com]
+    destination: http://127.0.0.1:8080
+`)
+
+	tests := map[string]bool{
+		"app.example.com":      true,
+		"APP.example.com.":     true,
+		"www.example.net":      true,
+		"api1.example.org":     true,
+		"internal.example.com": false,
+		"example.net":          false,
+		"api.example.org":      false,
+	}
+	for host, allowed := range tests {
+		if err := n.hostPolicy(context.Background(), host); (err == nil) != allowed {
+			t.Errorf("hostPolicy(%q) = %v, want allowed %v", host, err, allowed)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package namerouter
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+)
+
+var envVarName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// interpolate expands references in every scalar value of a parsed config
+// file:
+//
+//	${VAR}            value of the environment variable VAR
+//	${VAR:-default}   value of VAR, or default if it is unset or empty
+//	${file:/path}     contents of the file at path, without trailing newlines
+//	$${               a literal ${
+//
+// A reference to a variable that isn't set or a file that can't be read is
+// an error, rather than silently becoming an empty string. Relative file
+// paths are relative to the directory of the config file.
+func interpolate(file string, node *yaml.Node) ValidationErrors {
+	errs := ValidationErrors{}
+
+	switch node.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, c := range node.Content {
+			errs = append(errs, interpolate(file, c)...)
+		}
+	case yaml.MappingNode:
+		// Only values are expanded, keys are left as they are
+		for i := 1; i < len(node.Content); i += 2 {
+			errs = append(errs, interpolate(file, node.Content[i])...)
+		}
+	case yaml.ScalarNode:
+		if !strings.Contains(node.Value, "${") {
+			return errs
+		}
+		value, err := expandValue(filepath.Dir(file), node.Value)
+		if err != nil {
+			errs = append(errs, position{file: file, line: node.Line}.errorf("%s", err.Error()))
+			return errs
+		}
+		node.Value = value
+		if node.Style&(yaml.TaggedStyle|yaml.SingleQuotedStyle|yaml.DoubleQuotedStyle|yaml.LiteralStyle|yaml.FoldedStyle) == 0 {
+			// Let unquoted values be resolved again, so that "${PORT}" can
+			// still be decoded into an int
+			node.Tag = ""
+		}
+	}
+
+	return errs
+}
+
+func expandValue(dir string, value string) (string, error) {
+	var b strings.Builder
+
+	for {
+		i := strings.Index(value, "${")
+		if i < 0 {
+			b.WriteString(value)
+			return b.String(), nil
+		}
+
+		if i > 0 && value[i-1] == '$' {
+			b.WriteString(value[:i-1])
+			b.WriteString("${")
+			value = value[i+2:]
+			continue
+		}
+
+		end := strings.Index(value[i:], "}")
+		if end < 0 {
+			return "", fmt.Errorf("unterminated reference in %q", value)
+		}
+
+		b.WriteString(value[:i])
+		expanded, err := expandRef(dir, value[i+2:i+end])
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(expanded)
+		value = value[i+end+1:]
+	}
+}
+
+func expandRef(dir string, ref string) (string, error) {
+	if path, ok := strings.CutPrefix(ref, "file:"); ok {
+		if path == "" {
+			return "", fmt.Errorf("empty file reference")
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read referenced file: %w", err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+
+	name, def, hasDefault := strings.Cut(ref, ":-")
+	if !envVarName.MatchString(name) {
+		return "", fmt.Errorf("invalid environment variable name %q", name)
+	}
+
+	value, ok := os.LookupEnv(name)
+	if hasDefault && value == "" {
+		return def, nil
+	}
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+
+	return value, nil
+}
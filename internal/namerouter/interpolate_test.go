@@ -0,0 +1,87 @@
+package namerouter
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestExpandValue(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "token"), []byte("s3cret\n\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("NR_TEST_PORT", "8080")
+	t.Setenv("NR_TEST_EMPTY", "")
+
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{value: "plain", want: "plain"},
+		{value: "${NR_TEST_PORT}", want: "8080"},
+		{value: "http://localhost:${NR_TEST_PORT}/x", want: "http://localhost:8080/x"},
+		{value: "${NR_TEST_PORT}${NR_TEST_PORT}", want: "80808080"},
+		{value: "${NR_TEST_UNSET:-80}", want: "80"},
+		{value: "${NR_TEST_EMPTY:-80}", want: "80"},
+		{value: "${NR_TEST_PORT:-80}", want: "8080"},
+		{value: "${NR_TEST_UNSET:-}", want: ""},
+		{value: "${NR_TEST_EMPTY}", want: ""},
+		{value: "Bearer ${file:token}", want: "Bearer s3cret"},
+		{value: "${file:" + filepath.Join(dir, "token") + "}", want: "s3cret"},
+		{value: "$${NR_TEST_PORT}", want: "${NR_TEST_PORT}"},
+		{value: "$${NR_TEST_PORT} ${NR_TEST_PORT}", want: "${NR_TEST_PORT} 8080"},
+		{value: "$$${NR_TEST_PORT}", want: "$${NR_TEST_PORT}"},
+		{value: "cost $5", want: "cost $5"},
+		{value: "${NR_TEST_UNSET}", wantErr: true},
+		{value: "${NR_TEST_PORT", wantErr: true},
+		{value: "${1BAD}", wantErr: true},
+		{value: "${file:missing}", wantErr: true},
+		{value: "${file:}", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := expandValue(dir, tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expandValue(%q) = %q, want an error", tt.value, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expandValue(%q): %v", tt.value, err)
+			}
+			if got != tt.want {
+				t.Errorf("expandValue(%q) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInterpolateConfig(t *testing.T) {
+	t.Setenv("NR_TEST_PORT", "8080")
+	dir := t.TempDir()
+	file := writeConfig(t, dir, "c.yaml", `routes:
+  - internal: [app.local]
+    destination: http://127.0.0.1:${NR_TEST_PORT}
+`)
+	config, err := LoadConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := config.Routes[0].DestinationAddr; got != "http://127.0.0.1:8080" {
+		t.Errorf("destination = %q, want %q", got, "http://127.0.0.1:8080")
+	}
+
+	file = writeConfig(t, dir, "c.yaml", `routes:
+  - internal: [app.local]
+    destination: http://127.0.0.1:${NR_TEST_UNSET}
+`)
+	want := []string{"c.yaml:3: environment variable NR_TEST_UNSET is not set"}
+	if got := validationErrors(t, dir, file); !slices.Equal(got, want) {
+		t.Errorf("ValidateFile() =\n%q\nwant\n%q", got, want)
+	}
+}
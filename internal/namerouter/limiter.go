@@ -13,15 +13,30 @@ type visitor struct {
 	lastSeen time.Time
 }
 
-func (n *NameRouter) getVisitor(ip string) *rate.Limiter {
+// visitorKey returns the key a client is rate limited by, which is the
+// network of the configured prefix length that its IP address is on. IPv6
+// clients usually have a whole /64, so limiting single addresses wouldn't
+// limit them at all.
+func (rl *RateLimits) visitorKey(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		mask := net.CIDRMask(rl.IPv4Prefix, 8*net.IPv4len)
+		return (&net.IPNet{IP: ip4.Mask(mask), Mask: mask}).String()
+	}
+	if ip16 := ip.To16(); ip16 != nil {
+		mask := net.CIDRMask(rl.IPv6Prefix, 8*net.IPv6len)
+		return (&net.IPNet{IP: ip16.Mask(mask), Mask: mask}).String()
+	}
+	return ip.String()
+}
+
+func (n *NameRouter) getVisitor(ip string, internal bool) *rate.Limiter {
 	n.Lock()
 	defer n.Unlock()
 
 	v, ok := n.visitors[ip]
 	if !ok || v == nil {
-		ipAddr := net.ParseIP(ip)
 		var l *rate.Limiter
-		if ipAddr.IsPrivate() {
+		if internal {
 			l = rate.NewLimiter(rate.Limit(n.config.RateLimits.Internal.Rate), n.config.RateLimits.Internal.Burst)
 		} else {
 			l = rate.NewLimiter(rate.Limit(n.config.RateLimits.External.Rate), n.config.RateLimits.External.Burst)
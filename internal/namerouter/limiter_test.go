@@ -0,0 +1,64 @@
+package namerouter
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestVisitorKey(t *testing.T) {
+	tests := []struct {
+		ip   string
+		v4   int
+		v6   int
+		want string
+	}{
+		{ip: "203.0.113.9", v4: 32, v6: 64, want: "203.0.113.9/32"},
+		{ip: "203.0.113.9", v4: 24, v6: 64, want: "203.0.113.0/24"},
+		{ip: "::ffff:203.0.113.9", v4: 32, v6: 64, want: "203.0.113.9/32"},
+		{ip: "2001:db8:1:2:3:4:5:6", v4: 32, v6: 64, want: "2001:db8:1:2::/64"},
+		{ip: "2001:db8:1:2:3:4:5:6", v4: 32, v6: 48, want: "2001:db8:1::/48"},
+		{ip: "2001:db8:1:2:3:4:5:6", v4: 32, v6: 128, want: "2001:db8:1:2:3:4:5:6/128"},
+	}
+	for _, tt := range tests {
+		rl := &RateLimits{IPv4Prefix: tt.v4, IPv6Prefix: tt.v6}
+		if got := rl.visitorKey(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("visitorKey(%s) with /%d and /%d = %s, want %s", tt.ip, tt.v4, tt.v6, got, tt.want)
+		}
+	}
+}
+
+func TestRateLimitIPv6Prefix(t *testing.T) {
+	upstream := testUpstream(t, "ok")
+	n, _ := testServer(t, testConfig(t, `middlewares: [rateLimit, namehost]
+trustedProxies: [127.0.0.1/32]
+rateLimits:
+  external:
+    rate: 0.001
+    burst: 1
+routes:
+  - external: [app.test]
+    destination: `+upstream+`
+`))
+
+	send := func(clientIP string) int {
+		r := httptest.NewRequest(http.MethodGet, "http://app.test/", nil)
+		r.RemoteAddr = "127.0.0.1:1234"
+		r.Header.Set("X-Forwarded-For", clientIP)
+		w := httptest.NewRecorder()
+		n.Handler().ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if status := send("2001:db8:1:2::1"); status != http.StatusOK {
+		t.Fatalf("first request status = %d, want %d", status, http.StatusOK)
+	}
+	// Addresses in the same /64 share a limit
+	if status := send("2001:db8:1:2::2"); status != http.StatusTooManyRequests {
+		t.Errorf("same /64 status = %d, want %d", status, http.StatusTooManyRequests)
+	}
+	if status := send("2001:db8:1:3::1"); status != http.StatusOK {
+		t.Errorf("other /64 status = %d, want %d", status, http.StatusOK)
+	}
+}
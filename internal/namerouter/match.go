@@ -0,0 +1,142 @@
+package namerouter
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// MatchRule sends requests with particular methods, headers, query
+// parameters or cookies to a different destination than the route's own.
+// Every condition that is set must match. A header, query parameter or
+// cookie with an empty value matches any value, as long as it is present.
+type MatchRule struct {
+	Methods         []string          `yaml:"methods,omitempty"`
+	Headers         map[string]string `yaml:"headers,omitempty"`
+	Query           map[string]string `yaml:"query,omitempty"`
+	Cookies         map[string]string `yaml:"cookies,omitempty"`
+	DestinationAddr string            `yaml:"destination"`
+}
+
+// matchRoute is a MatchRule with the proxy built from it. It is the handler
+// of the rule's mux route, so that it can be found from a mux.RouteMatch.
+type matchRoute struct {
+	*MatchRule
+	proxy *httputil.ReverseProxy
+}
+
+func (m *matchRoute) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.proxy.ServeHTTP(w, r)
+}
+
+// buildMatchRouter creates a mux router with a route for each rule, in
+// order, so that the first rule matching a request wins
+func buildMatchRouter(rules []*MatchRule) (*mux.Router, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+
+	router := mux.NewRouter()
+	for _, rule := range rules {
+		if rule == nil {
+			continue
+		}
+		u, err := url.Parse(rule.DestinationAddr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse URL for match destination: %w", err)
+		}
+
+		route := router.NewRoute().Handler(&matchRoute{
+			MatchRule: rule,
+			proxy:     httputil.NewSingleHostReverseProxy(u),
+		})
+		if len(rule.Methods) > 0 {
+			route.Methods(rule.Methods...)
+		}
+		if len(rule.Headers) > 0 {
+			route.Headers(pairs(rule.Headers)...)
+		}
+		if len(rule.Query) > 0 {
+			route.Queries(pairs(rule.Query)...)
+		}
+		for name, value := range rule.Cookies {
+			name, value := name, value
+			route.MatcherFunc(func(r *http.Request, _ *mux.RouteMatch) bool {
+				c, err := r.Cookie(name)
+				return err == nil && (value == "" || c.Value == value)
+			})
+		}
+	}
+
+	return router, nil
+}
+
+// pairs flattens a map into the key, value pairs mux matchers take
+func pairs(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	out := make([]string, 0, len(m)*2)
+	for _, k := range keys {
+		out = append(out, k, m[k])
+	}
+	return out
+}
+
+// matchRule returns the first match rule of the route matching a request,
+// or nil if there is none
+func (nh *Namehost) matchRule(r *http.Request) *matchRoute {
+	if nh.matcher == nil {
+		return nil
+	}
+	var m mux.RouteMatch
+	if !nh.matcher.Match(r, &m) {
+		return nil
+	}
+	rule, _ := m.Handler.(*matchRoute)
+	return rule
+}
+
+func (rule *MatchRule) validate(nh *Namehost, i int) ValidationErrors {
+	errs := ValidationErrors{}
+
+	if len(rule.Methods) == 0 && len(rule.Headers) == 0 && len(rule.Query) == 0 && len(rule.Cookies) == 0 {
+		errs = append(errs, nh.pos.errorf("match rule %d has no conditions", i+1))
+	}
+	for _, method := range rule.Methods {
+		if method == "" || strings.ContainsAny(method, " \t/") {
+			errs = append(errs, nh.pos.errorf("match rule %d has an invalid method %q", i+1, method))
+		}
+	}
+	for name := range rule.Headers {
+		if name == "" {
+			errs = append(errs, nh.pos.errorf("match rule %d has an empty header name", i+1))
+		}
+	}
+	for name := range rule.Query {
+		if name == "" {
+			errs = append(errs, nh.pos.errorf("match rule %d has an empty query parameter name", i+1))
+		}
+	}
+	for name := range rule.Cookies {
+		if name == "" {
+			errs = append(errs, nh.pos.errorf("match rule %d has an empty cookie name", i+1))
+		}
+	}
+
+	if rule.DestinationAddr == "" {
+		errs = append(errs, nh.pos.errorf("match rule %d is missing a destination", i+1))
+	} else if err := validateDestination(rule.DestinationAddr); err != nil {
+		errs = append(errs, nh.pos.errorf("match rule %d: %s", i+1, err.Error()))
+	}
+
+	return errs
+}
@@ -0,0 +1,98 @@
+package namerouter
+
+import (
+	"io"
+	"net/http"
+	"slices"
+	"testing"
+)
+
+func TestMatchRules(t *testing.T) {
+	_, s := testServer(t, testConfig(t, routerConfig(`    destination: `+pathUpstream(t, "root")+`
+    match:
+      - methods: [POST, PUT]
+        headers:
+          X-Version: "2"
+        destination: `+pathUpstream(t, "write-v2")+`
+      - headers:
+          X-Beta: ""
+        destination: `+pathUpstream(t, "beta")+`
+      - query:
+          preview: "true"
+        destination: `+pathUpstream(t, "preview")+`
+      - cookies:
+          canary: "1"
+        destination: `+pathUpstream(t, "canary")+`
+    paths:
+      - path: /api
+        destination: `+pathUpstream(t, "api")+`
+`)))
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		headers map[string]string
+		want    string
+	}{
+		{name: "no rule", method: http.MethodGet, path: "/", want: "root /"},
+		{name: "method and header", method: http.MethodPost, path: "/", headers: map[string]string{"X-Version": "2"}, want: "write-v2 /"},
+		{name: "method without header", method: http.MethodPost, path: "/", want: "root /"},
+		{name: "header without method", method: http.MethodGet, path: "/", headers: map[string]string{"X-Version": "2"}, want: "root /"},
+		{name: "any header value", method: http.MethodGet, path: "/", headers: map[string]string{"X-Beta": "whatever"}, want: "beta /"},
+		{name: "first rule wins", method: http.MethodPut, path: "/", headers: map[string]string{"X-Version": "2", "X-Beta": "1"}, want: "write-v2 /"},
+		{name: "query", method: http.MethodGet, path: "/a?preview=true", want: "preview /a?preview=true"},
+		{name: "query value", method: http.MethodGet, path: "/a?preview=false", want: "root /a?preview=false"},
+		{name: "cookie", method: http.MethodGet, path: "/", headers: map[string]string{"Cookie": "canary=1"}, want: "canary /"},
+		{name: "cookie value", method: http.MethodGet, path: "/", headers: map[string]string{"Cookie": "canary=2"}, want: "root /"},
+		{name: "rules before paths", method: http.MethodGet, path: "/api/x", headers: map[string]string{"X-Beta": "1"}, want: "beta /api/x"},
+		{name: "path", method: http.MethodGet, path: "/api/x", want: "api /api/x"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, s.URL+tt.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Host = "app.test"
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			resp, err := s.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := string(body); got != tt.want {
+				t.Errorf("body = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchRuleErrors(t *testing.T) {
+	dir := t.TempDir()
+	file := writeConfig(t, dir, "c.yaml", `routes:
+  - internal: [app.local]
+    destination: http://127.0.0.1:8080
+    match:
+      - destination: http://127.0.0.1:8081
+      - methods: ["GE T"]
+        destination: http://127.0.0.1:8082
+      - headers:
+          X-Beta: "1"
+`)
+	want := []string{
+		"c.yaml:2: match rule 1 has no conditions",
+		"c.yaml:2: match rule 2 has an invalid method \"GE T\"",
+		"c.yaml:2: match rule 3 is missing a destination",
+	}
+	if got := validationErrors(t, dir, file); !slices.Equal(got, want) {
+		t.Errorf("ValidateFile() =\n%q\nwant\n%q", got, want)
+	}
+}
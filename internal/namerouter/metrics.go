@@ -0,0 +1,52 @@
+package namerouter
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+// metrics are the counters served on the health server. expvar isn't used
+// for them, because importing it registers /debug/vars on
+// http.DefaultServeMux in every program that embeds namerouter. They are
+// only created by package variables, so the map isn't changed after init.
+var metrics = make(map[string]*metric)
+
+// metric is a set of counters, keyed by route and destination
+type metric struct {
+	sync.Mutex
+	values map[string]float64
+}
+
+func newMetric(name string) *metric {
+	m := &metric{values: make(map[string]float64)}
+	metrics[name] = m
+	return m
+}
+
+func (m *metric) Add(key string, delta int64) {
+	m.AddFloat(key, float64(delta))
+}
+
+func (m *metric) AddFloat(key string, delta float64) {
+	m.Lock()
+	defer m.Unlock()
+	m.values[key] += delta
+}
+
+// serveMetrics writes every metric as a JSON object of counters
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	out := make(map[string]map[string]float64, len(metrics))
+	for name, m := range metrics {
+		m.Lock()
+		values := make(map[string]float64, len(m.values))
+		for k, v := range m.values {
+			values[k] = v
+		}
+		m.Unlock()
+		out[name] = values
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(out)
+}
@@ -2,6 +2,7 @@ package namerouter
 
 import (
 	"context"
+	"fmt"
 	"net"
 	"net/http"
 	"strings"
@@ -18,6 +19,15 @@ func (n *NameRouter) hostHeaderMiddleware(next http.Handler) http.Handler {
 			)
 			return
 		}
+		if r.TLS != nil && r.TLS.ServerName != "" && n.currentConfig().RequireSNIMatch &&
+			normalizeHost(r.TLS.ServerName) != normalizeHost(r.Host) {
+			http.Error(w, "host does not match TLS server name", http.StatusMisdirectedRequest)
+			n.logger.Error("host header does not match TLS server name",
+				zap.String("request host", r.Host),
+				zap.String("server name", r.TLS.ServerName),
+			)
+			return
+		}
 		if next != nil {
 			next.ServeHTTP(w, r)
 		}
@@ -26,11 +36,10 @@ func (n *NameRouter) hostHeaderMiddleware(next http.Handler) http.Handler {
 
 func (n *NameRouter) externalToHTTPSMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ip := net.ParseIP(strings.Split(r.RemoteAddr, ":")[0])
-
-		if !ip.IsPrivate() {
-			newURI := "https://" + r.Host + r.URL.String()
-			http.Redirect(w, r, newURI, http.StatusFound)
+		// Behind a trusted proxy the request may have been https already
+		client := n.client(r)
+		if !client.Internal && client.scheme(r) != "https" {
+			http.Redirect(w, r, httpsLocation(r, client), http.StatusFound)
 			return
 		}
 		if next != nil {
@@ -39,18 +48,28 @@ func (n *NameRouter) externalToHTTPSMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// httpsLocation returns the https URL of a request. The port is dropped, as
+// it belongs to the http listener.
+func httpsLocation(r *http.Request, client *Client) string {
+	host := normalizeHost(client.requestHost(r))
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	return "https://" + host + r.URL.RequestURI()
+}
+
 func (n *NameRouter) rateLimiter(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ip, _, err := net.SplitHostPort(r.RemoteAddr)
-		if err != nil {
+		client := n.client(r)
+		if client.IP == nil {
 			n.logger.Error("failed to parse remote addr",
-				zap.Error(err),
+				zap.String("remote addr", r.RemoteAddr),
 			)
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
 
-		limiter := n.getVisitor(ip)
+		limiter := n.getVisitor(n.currentConfig().RateLimits.visitorKey(client.IP), client.Internal)
 		if !limiter.Allow() {
 			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
 			return
@@ -65,16 +84,17 @@ func (n *NameRouter) rateLimiter(next http.Handler) http.Handler {
 func (n *NameRouter) captureClosedConnIP(conn net.Conn, state http.ConnState) {
 	if state == http.StateClosed || state == http.StateHijacked {
 		if conn.RemoteAddr() != nil {
-			parts := strings.Split(conn.RemoteAddr().String(), ":")
-			if len(parts) > 0 {
-				ip := net.ParseIP(parts[0])
-				if !ip.IsPrivate() {
-					n.logger.Info("closed remote connection",
-						zap.String("IP", ip.String()),
-						zap.String("local addr", conn.LocalAddr().String()),
-						zap.String("Conn state", state.String()),
-					)
-				}
+			host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+			if err != nil {
+				return
+			}
+			ip := net.ParseIP(host)
+			if !n.isInternal(ip) {
+				n.logger.Info("closed remote connection",
+					zap.String("IP", ip.String()),
+					zap.String("local addr", conn.LocalAddr().String()),
+					zap.String("Conn state", state.String()),
+				)
 			}
 		}
 	}
@@ -82,7 +102,7 @@ func (n *NameRouter) captureClosedConnIP(conn net.Conn, state http.ConnState) {
 
 func (n *NameRouter) sourcePort(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Host != "" && net.ParseIP(r.Host) == nil {
+		if !isSourcePortRequest(r) {
 			n.logger.Info("not a sourceport connection, not ip",
 				zap.String("host", r.Host),
 			)
@@ -90,10 +110,7 @@ func (n *NameRouter) sourcePort(next http.Handler) http.Handler {
 			return
 		}
 
-		ctx := r.Context()
-
-		srvAddr := ctx.Value(http.LocalAddrContextKey).(net.Addr)
-		_, port, err := net.SplitHostPort(srvAddr.String())
+		port, err := localPort(r)
 		if err != nil {
 			n.logger.Error("failed to split request hostport",
 				zap.String("host", r.Host),
@@ -102,13 +119,13 @@ func (n *NameRouter) sourcePort(next http.Handler) http.Handler {
 			return
 		}
 
-		dr, ok := n.defaultRoute[port]
+		dr, ok := n.routesFor(r).defaultRoute[port]
 		if ok && dr != nil {
 			n.logger.Info("sending sourcePort default request",
 				zap.String("dest", dr.DestinationAddr),
 				zap.String("sourcePort", *dr.SourcePort),
 			)
-			dr.proxy.ServeHTTP(w, r)
+			dr.handler.ServeHTTP(w, r)
 			return
 		}
 		n.logger.Info("not a sourceport connection",
@@ -119,6 +136,22 @@ func (n *NameRouter) sourcePort(next http.Handler) http.Handler {
 	})
 }
 
+// isSourcePortRequest reports whether a request should be checked for a
+// sourcePort default route, which is when it has no Host or the Host is an IP
+func isSourcePortRequest(r *http.Request) bool {
+	return r.Host == "" || net.ParseIP(normalizeHost(r.Host)) != nil
+}
+
+// localPort returns the port of the listener a request came in on
+func localPort(r *http.Request) (string, error) {
+	srvAddr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	if !ok {
+		return "", fmt.Errorf("missing local address")
+	}
+	_, port, err := net.SplitHostPort(srvAddr.String())
+	return port, err
+}
+
 func (n *NameRouter) namehostCtx(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		nh := n.getNamehost(r)
@@ -0,0 +1,147 @@
+package namerouter
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+// Names of the built in middleware, which can be used to order the global
+// middleware chain
+const (
+	MiddlewareRateLimit     = "rateLimit"
+	MiddlewareNamehost      = "namehost"
+	MiddlewareSourcePort    = "sourcePort"
+	MiddlewareHostHeader    = "hostHeader"
+	MiddlewareHTTPSRedirect = "httpsRedirect"
+)
+
+// defaultMiddlewares is the global middleware chain used when the config
+// doesn't set one. The first middleware sees a request first.
+var defaultMiddlewares = []string{
+	MiddlewareRateLimit,
+	MiddlewareNamehost,
+	MiddlewareSourcePort,
+	MiddlewareHostHeader,
+	MiddlewareHTTPSRedirect,
+}
+
+var builtinMiddlewares = map[string]bool{
+	MiddlewareRateLimit:     true,
+	MiddlewareNamehost:      true,
+	MiddlewareSourcePort:    true,
+	MiddlewareHostHeader:    true,
+	MiddlewareHTTPSRedirect: true,
+}
+
+var (
+	middlewareLock     sync.RWMutex
+	middlewareRegistry = make(map[string]mux.MiddlewareFunc)
+)
+
+// RegisterMiddleware registers a middleware under a name, so that it can be
+// used in the global middleware chain and by routes in the config. It is
+// usually called from an init function, before any config is loaded.
+func RegisterMiddleware(name string, mw mux.MiddlewareFunc) error {
+	if name == "" {
+		return fmt.Errorf("middleware name is empty")
+	}
+	if mw == nil {
+		return fmt.Errorf("middleware %s is nil", name)
+	}
+
+	middlewareLock.Lock()
+	defer middlewareLock.Unlock()
+
+	if builtinMiddlewares[name] {
+		return fmt.Errorf("middleware %s is built in", name)
+	}
+	if _, ok := middlewareRegistry[name]; ok {
+		return fmt.Errorf("middleware %s is already registered", name)
+	}
+
+	middlewareRegistry[name] = mw
+
+	return nil
+}
+
+func registeredMiddleware(name string) (mux.MiddlewareFunc, bool) {
+	middlewareLock.RLock()
+	defer middlewareLock.RUnlock()
+
+	mw, ok := middlewareRegistry[name]
+	return mw, ok
+}
+
+// globalMiddlewares returns the names of the global middleware chain
+func (c *Config) globalMiddlewares() []string {
+	if len(c.Middlewares) == 0 {
+		return defaultMiddlewares
+	}
+	return c.Middlewares
+}
+
+func (n *NameRouter) builtinMiddleware(name string) mux.MiddlewareFunc {
+	switch name {
+	case MiddlewareRateLimit:
+		return n.rateLimiter
+	case MiddlewareNamehost:
+		return n.namehostCtx
+	case MiddlewareSourcePort:
+		return n.sourcePort
+	case MiddlewareHostHeader:
+		return n.hostHeaderMiddleware
+	case MiddlewareHTTPSRedirect:
+		return n.externalToHTTPSMiddleware
+	}
+	return nil
+}
+
+// buildChain wraps final in the named middleware, followed by any added
+// with WithMiddleware. The HTTPS redirect is left out of the chain for the
+// HTTPS listener.
+func (n *NameRouter) buildChain(names []string, https bool, final http.Handler) (http.Handler, error) {
+	mwf := []mux.MiddlewareFunc{}
+	for _, name := range names {
+		if https && name == MiddlewareHTTPSRedirect {
+			continue
+		}
+		if mw := n.builtinMiddleware(name); mw != nil {
+			mwf = append(mwf, mw)
+			continue
+		}
+		mw, ok := registeredMiddleware(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown middleware %s", name)
+		}
+		mwf = append(mwf, mw)
+	}
+	mwf = append(mwf, n.opts.middleware...)
+
+	return chain(mwf, final), nil
+}
+
+// routeChain wraps a route's handler in the middleware it names
+func routeChain(names []string, final http.Handler) (http.Handler, error) {
+	mwf := []mux.MiddlewareFunc{}
+	for _, name := range names {
+		mw, ok := registeredMiddleware(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown middleware %s", name)
+		}
+		mwf = append(mwf, mw)
+	}
+
+	return chain(mwf, final), nil
+}
+
+// chain applies middleware so that the first one sees a request first
+func chain(mwf []mux.MiddlewareFunc, final http.Handler) http.Handler {
+	h := final
+	for i := len(mwf) - 1; i >= 0; i-- {
+		h = mwf[i](h)
+	}
+	return h
+}
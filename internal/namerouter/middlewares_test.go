@@ -0,0 +1,99 @@
+package namerouter
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// registerTestMiddleware registers a middleware that adds its name to the
+// X-Chain request header. It is only registered once, so that tests can be
+// run more than once.
+func registerTestMiddleware(t *testing.T, name string) {
+	t.Helper()
+	if _, ok := registeredMiddleware(name); ok {
+		return
+	}
+	err := RegisterMiddleware(name, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Header.Add("X-Chain", name)
+			next.ServeHTTP(w, r)
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRegisterMiddleware(t *testing.T) {
+	registerTestMiddleware(t, "test-registered")
+	mw := func(next http.Handler) http.Handler { return next }
+
+	tests := map[string]struct {
+		name string
+		mw   func(http.Handler) http.Handler
+	}{
+		"empty name": {name: "", mw: mw},
+		"nil":        {name: "test-nil"},
+		"built in":   {name: MiddlewareRateLimit, mw: mw},
+		"duplicate":  {name: "test-registered", mw: mw},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := RegisterMiddleware(tt.name, tt.mw); err == nil {
+				t.Errorf("RegisterMiddleware(%q) succeeded, want an error", tt.name)
+			}
+		})
+	}
+}
+
+func TestMiddlewareChains(t *testing.T) {
+	registerTestMiddleware(t, "test-global")
+	registerTestMiddleware(t, "test-route")
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, strings.Join(r.Header.Values("X-Chain"), ","))
+	}))
+	defer upstream.Close()
+
+	_, s := testServer(t, testConfig(t, `middlewares: [namehost, test-global]
+routes:
+  - internal: [a.test]
+    destination: `+upstream.URL+`
+    middlewares: [test-route]
+  - internal: [b.test]
+    destination: `+upstream.URL+`
+`))
+
+	tests := map[string]string{
+		"a.test": "test-global,test-route",
+		"b.test": "test-global",
+	}
+	for host, want := range tests {
+		if _, body := get(t, s, host, "/"); body != want {
+			t.Errorf("%s: middleware ran %q, want %q", host, body, want)
+		}
+	}
+}
+
+func TestMiddlewareErrors(t *testing.T) {
+	registerTestMiddleware(t, "test-global")
+	dir := t.TempDir()
+	file := writeConfig(t, dir, "c.yaml", `middlewares: [namehost, nope, namehost]
+routes:
+  - internal: [a.test]
+    destination: http://127.0.0.1:8080
+    middlewares: [test-global, test-global, rateLimit]
+`)
+	want := []string{
+		"c.yaml:1: unknown middleware \"nope\"",
+		"c.yaml:1: middleware \"namehost\" is listed more than once",
+		"c.yaml:3: middleware \"test-global\" is listed more than once",
+		"c.yaml:3: built in middleware \"rateLimit\" can only be used in the global middlewares",
+	}
+	if got := validationErrors(t, dir, file); !slices.Equal(got, want) {
+		t.Errorf("ValidateFile() =\n%q\nwant\n%q", got, want)
+	}
+}
@@ -0,0 +1,373 @@
+package namerouter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.yaml.in/yaml/v3"
+)
+
+const (
+	// defaultMirrorMaxBody is the largest request body copied to a mirror
+	// when a mirror doesn't set maxBodyBytes
+	defaultMirrorMaxBody = 1 << 20
+	// mirrorTimeout is how long a mirror has to respond
+	mirrorTimeout = 30 * time.Second
+	// maxMirrorInFlight is how many requests can be in flight to a single
+	// mirror. Requests beyond it aren't mirrored.
+	maxMirrorInFlight = 100
+	// mirrorDialTimeout is how long connecting to a mirror can take
+	mirrorDialTimeout = 10 * time.Second
+	// mirrorMaxIdleConns is how many idle connections are kept open to each
+	// mirror
+	mirrorMaxIdleConns = 10
+	// mirrorIdleConnTimeout is how long an idle connection to a mirror is
+	// kept open
+	mirrorIdleConnTimeout = 90 * time.Second
+)
+
+// Mirror metrics, keyed by route and mirror
+var (
+	mirrorRequests = newMetric("namerouter_mirror_requests")
+	mirrorErrors   = newMetric("namerouter_mirror_errors")
+	mirrorSkipped  = newMetric("namerouter_mirror_skipped")
+	// mirrorLatencyDiff is the total of how much longer mirrors took to
+	// respond than the primary destination, in seconds
+	mirrorLatencyDiff = newMetric("namerouter_mirror_latency_diff_seconds")
+)
+
+// Mirror is a destination that receives a copy of requests proxied by a
+// route. Its responses are discarded. It can be written as just the
+// destination.
+type Mirror struct {
+	DestinationAddr string `yaml:"destination"`
+	// Percent is the percentage of requests that are mirrored, 100 by
+	// default
+	Percent *float64 `yaml:"percent,omitempty"`
+	// MaxBodyBytes is the largest request body that is mirrored. Requests
+	// with larger bodies aren't mirrored. It defaults to 1MiB.
+	MaxBodyBytes int64 `yaml:"maxBodyBytes,omitempty"`
+}
+
+func (m *Mirror) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		m.DestinationAddr = value.Value
+		return nil
+	}
+	type plain Mirror
+	return value.Decode((*plain)(m))
+}
+
+func (m *Mirror) percent() float64 {
+	if m.Percent == nil {
+		return 100
+	}
+	return *m.Percent
+}
+
+func (m *Mirror) maxBody() int64 {
+	if m.MaxBodyBytes == 0 {
+		return defaultMirrorMaxBody
+	}
+	return m.MaxBodyBytes
+}
+
+// mirrorClient sends mirrored requests. Redirects are returned rather than
+// followed, as they would be to a client. It has a transport of its own, so
+// that slow mirrors don't use up the connections to primary destinations.
+var mirrorClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   mirrorDialTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConnsPerHost:   mirrorMaxIdleConns,
+		IdleConnTimeout:       mirrorIdleConnTimeout,
+		TLSHandshakeTimeout:   mirrorDialTimeout,
+		ResponseHeaderTimeout: mirrorTimeout,
+	},
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// hopHeaders only apply to a single connection, so they aren't copied to
+// mirrored requests
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// mirror is a Mirror with its destination parsed
+type mirror struct {
+	*Mirror
+	// key labels the mirror's metrics
+	key      string
+	target   *url.URL
+	inFlight chan struct{}
+}
+
+func buildMirrors(route string, mirrors []*Mirror) ([]*mirror, error) {
+	built := make([]*mirror, 0, len(mirrors))
+	for _, m := range mirrors {
+		if m == nil {
+			continue
+		}
+		u, err := url.Parse(m.DestinationAddr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse URL for mirror: %w", err)
+		}
+
+		built = append(built, &mirror{
+			Mirror:   m,
+			key:      route + "/" + u.Host,
+			target:   u,
+			inFlight: make(chan struct{}, maxMirrorInFlight),
+		})
+	}
+	return built, nil
+}
+
+// request turns a copy of a proxied request into the request sent to the
+// mirror, the same way the route's proxy does for its destination
+func (m *mirror) request(r *http.Request) *http.Request {
+	pr := &httputil.ProxyRequest{In: r, Out: r}
+	host := r.Host
+	pr.SetURL(m.target)
+	r.Host = host
+	r.RequestURI = ""
+	for _, name := range hopHeaders {
+		r.Header.Del(name)
+	}
+	if ip, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		if prior := r.Header.Values("X-Forwarded-For"); len(prior) > 0 {
+			ip = strings.Join(prior, ", ") + ", " + ip
+		}
+		r.Header.Set("X-Forwarded-For", ip)
+	}
+	return r
+}
+
+// primaryTiming is how long the primary destination took to respond. done
+// is closed once it is set.
+type primaryTiming struct {
+	done chan struct{}
+	took time.Duration
+}
+
+// mirrorBody copies a request body as the primary destination reads it, so
+// that mirrors get a copy without the primary request waiting for the body
+// to be buffered. done is closed when the body has been read to the end or
+// closed. complete is set before that if all of it was copied.
+type mirrorBody struct {
+	io.ReadCloser
+	limit    int64
+	buf      bytes.Buffer
+	overflow bool
+	complete bool
+	once     sync.Once
+	done     chan struct{}
+}
+
+func (b *mirrorBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 && !b.overflow {
+		if int64(b.buf.Len()+n) > b.limit {
+			b.overflow = true
+		} else {
+			b.buf.Write(p[:n])
+		}
+	}
+	if err == io.EOF {
+		b.finish(true)
+	}
+	return n, err
+}
+
+func (b *mirrorBody) Close() error {
+	b.finish(false)
+	return b.ReadCloser.Close()
+}
+
+// finish marks the body as done. The body is only complete if it was read
+// to the end without going over the limit.
+func (b *mirrorBody) finish(complete bool) {
+	b.once.Do(func() {
+		b.complete = complete && !b.overflow
+		close(b.done)
+	})
+}
+
+// startMirrors sends a copy of a request to each mirror of the route that
+// samples it. The returned request copies its body for the mirrors as the
+// primary destination reads it, and mirrors are sent once it has been read.
+// The returned function must be called with how long the primary
+// destination took to respond, it is nil if nothing was mirrored.
+func (n *NameRouter) startMirrors(nh *Namehost, r *http.Request) (*http.Request, func(time.Duration)) {
+	var sampled []*mirror
+	var limit int64
+	for _, m := range nh.mirrors {
+		if rand.Float64()*100 >= m.percent() {
+			continue
+		}
+		if r.ContentLength > m.maxBody() {
+			mirrorSkipped.Add(m.key, 1)
+			continue
+		}
+		select {
+		case m.inFlight <- struct{}{}:
+		default:
+			mirrorSkipped.Add(m.key, 1)
+			continue
+		}
+		sampled = append(sampled, m)
+		limit = max(limit, m.maxBody())
+	}
+	if len(sampled) == 0 {
+		return r, nil
+	}
+
+	var body *mirrorBody
+	if r.Body != nil && r.Body != http.NoBody {
+		body = &mirrorBody{ReadCloser: r.Body, limit: limit, done: make(chan struct{})}
+		r.Body = body
+	}
+
+	primary := &primaryTiming{done: make(chan struct{})}
+	for _, m := range sampled {
+		// Mirrors get a context of their own, so that they aren't cancelled
+		// with the client's request
+		mr := r.Clone(context.Background())
+		if nh.HostHeader == HostHeaderDestination {
+			mr.Host = m.target.Host
+		}
+		mr.Body = http.NoBody
+		go n.sendMirror(m, mr, body, primary)
+	}
+
+	return r, func(took time.Duration) {
+		if body != nil {
+			body.finish(false)
+		}
+		primary.took = took
+		close(primary.done)
+	}
+}
+
+// sendMirror sends a copy of a request to a mirror once its body has been
+// read, and records how it went
+func (n *NameRouter) sendMirror(m *mirror, r *http.Request, body *mirrorBody, primary *primaryTiming) {
+	defer func() { <-m.inFlight }()
+	defer func() {
+		if err := recover(); err != nil {
+			mirrorErrors.Add(m.key, 1)
+			n.logger.Error("mirror request panicked",
+				zap.String("Host", r.Host),
+				zap.String("mirror", m.DestinationAddr),
+				zap.Any("error", err),
+			)
+		}
+	}()
+
+	if body != nil {
+		<-body.done
+		if !body.complete || int64(body.buf.Len()) > m.maxBody() {
+			mirrorSkipped.Add(m.key, 1)
+			return
+		}
+		r.ContentLength = int64(body.buf.Len())
+		if r.ContentLength > 0 {
+			r.Body = io.NopCloser(bytes.NewReader(body.buf.Bytes()))
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), mirrorTimeout)
+	defer cancel()
+	r = m.request(r.WithContext(ctx))
+
+	start := time.Now()
+	status := 0
+	resp, err := mirrorClient.Do(r)
+	if err == nil {
+		status = resp.StatusCode
+		// The response is read to the end so that the timing covers it, and
+		// the connection can be reused
+		_, err = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	took := time.Since(start)
+
+	mirrorRequests.Add(m.key, 1)
+	if err != nil || status >= http.StatusInternalServerError {
+		mirrorErrors.Add(m.key, 1)
+		fields := []zap.Field{
+			zap.String("Host", r.Host),
+			zap.String("mirror", m.DestinationAddr),
+			zap.Int("status", status),
+			zap.String("Request", r.URL.RequestURI()),
+		}
+		if err != nil {
+			fields = append(fields, zap.Error(err))
+		}
+		n.logger.Debug("mirror request failed", fields...)
+	}
+
+	select {
+	case <-primary.done:
+		mirrorLatencyDiff.AddFloat(m.key, (took - primary.took).Seconds())
+	case <-ctx.Done():
+	}
+}
+
+// describe describes what a mirror receives
+func (m *mirror) describe() string {
+	return fmt.Sprintf("%s gets %.4g%% of requests with bodies up to %d bytes", m.DestinationAddr, m.percent(), m.maxBody())
+}
+
+func (nh *Namehost) validateMirrors() ValidationErrors {
+	errs := ValidationErrors{}
+
+	seen := make(map[string]bool)
+	for _, m := range nh.Mirror {
+		if m == nil {
+			errs = append(errs, nh.pos.errorf("empty mirror"))
+			continue
+		}
+		if m.DestinationAddr == "" {
+			errs = append(errs, nh.pos.errorf("mirror is missing a destination"))
+		} else if err := validateDestination(m.DestinationAddr); err != nil {
+			errs = append(errs, nh.pos.errorf("mirror: %s", err.Error()))
+		} else if seen[m.DestinationAddr] {
+			errs = append(errs, nh.pos.errorf("mirror %s is listed more than once", m.DestinationAddr))
+		}
+		seen[m.DestinationAddr] = true
+
+		if p := m.percent(); p < 0 || p > 100 {
+			errs = append(errs, nh.pos.errorf("mirror %s percent %g must be between 0 and 100", m.DestinationAddr, p))
+		}
+		if m.MaxBodyBytes < 0 {
+			errs = append(errs, nh.pos.errorf("mirror %s has a negative maxBodyBytes", m.DestinationAddr))
+		}
+	}
+
+	return errs
+}
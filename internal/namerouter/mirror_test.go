@@ -0,0 +1,64 @@
+package namerouter
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMirror(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		fmt.Fprintf(w, "primary %s", data)
+	}))
+	defer upstream.Close()
+
+	mirrored := make(chan string, 10)
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		mirrored <- fmt.Sprintf("%s %s %s %s", r.Method, r.Host, r.URL.RequestURI(), data)
+		// Mirror responses are discarded
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer mirror.Close()
+
+	_, s := testServer(t, testConfig(t, routerConfig(fmt.Sprintf(`    destination: %s
+    mirror:
+      - destination: %s
+        maxBodyBytes: 10
+`, upstream.URL, mirror.URL))))
+
+	status, body := do(t, s, http.MethodPost, "app.test", "/a", "hello")
+	if status != http.StatusOK || body != "primary hello" {
+		t.Fatalf("response = %d %q, want 200 \"primary hello\"", status, body)
+	}
+	select {
+	case got := <-mirrored:
+		if want := "POST app.test /a hello"; got != want {
+			t.Errorf("mirrored %q, want %q", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("request wasn't mirrored")
+	}
+
+	// Bodies over the limit aren't mirrored, but are still proxied
+	status, body = do(t, s, http.MethodPost, "app.test", "/b", "this is too long")
+	if status != http.StatusOK || body != "primary this is too long" {
+		t.Fatalf("response = %d %q, want 200 \"primary this is too long\"", status, body)
+	}
+	status, _ = get(t, s, "app.test", "/c")
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, want 200", status)
+	}
+	select {
+	case got := <-mirrored:
+		if want := "GET app.test /c "; got != want {
+			t.Errorf("mirrored %q, want %q", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("request wasn't mirrored")
+	}
+}
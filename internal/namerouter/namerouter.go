@@ -3,283 +3,563 @@ package namerouter
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
 	"net/http/httputil"
-	"net/url"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/gorilla/mux"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"go.yaml.in/yaml/v3"
 	"golang.org/x/crypto/acme/autocert"
-	"golang.org/x/time/rate"
 )
 
 type NameRouter struct {
 	svr              *http.Server
 	httpSvr          *http.Server
 	healthSvr        *http.Server
+	adminSvr         *http.Server
+	servers          []*http.Server
+	router           *mux.Router
+	opts             *options
 	logger           *zap.Logger
-	nameHosts        map[string]*Namehost
-	defaultRoute     map[string]*Namehost
+	routes           *routeTable
 	visitors         map[string]*visitor
 	backgroundCtx    context.Context
 	backgroundCancel context.CancelFunc
 	config           *Config
+	// reloadLock serializes config changes from reloads and the admin API
+	reloadLock sync.Mutex
 	sync.RWMutex
 }
 
-type Config struct {
-	RateLimits *RateLimits `yaml:"rateLimits"`
-	Routes     []*Namehost `yaml:"routes"`
-	DoSSL      bool        `yaml:"doSSL"`
-	Email      string      `yaml:"email"`
-	Debug      bool        `yaml:"debug"`
-	HTTPSPort  int         `yaml:"httpsPort"`
-	HTTPPort   int         `yaml:"httpPort"`
+type Namehost struct {
+	Name            string   `yaml:"name,omitempty"`
+	InternalHosts   []string `yaml:"internal,omitempty"`
+	ExternalHosts   []string `yaml:"external,omitempty"`
+	DestinationAddr string   `yaml:"destination,omitempty"`
+	// Destinations split traffic between several destinations by weight,
+	// instead of sending it all to DestinationAddr
+	Destinations []*WeightedDestination `yaml:"destinations,omitempty"`
+	// StickyCookie keeps clients on the destination they were first sent to
+	// with a cookie of this name
+	StickyCookie string  `yaml:"stickyCookie,omitempty"`
+	SourcePort   *string `yaml:"sourcePort,omitempty"`
+	Always404    bool    `yaml:"always404,omitempty"`
+	// Redirect redirects requests instead of proxying them
+	Redirect *Redirect `yaml:"redirect,omitempty"`
+	// Static serves files from a directory instead of proxying requests
+	Static *Static `yaml:"static,omitempty"`
+	// Paths send parts of the route to other destinations. Requests that
+	// don't match any of them go to DestinationAddr.
+	Paths []*Path `yaml:"paths,omitempty"`
+	// Match rules send requests to other destinations based on their method,
+	// headers, query or cookies. They are checked in order, before Paths.
+	Match []*MatchRule `yaml:"match,omitempty"`
+	// Mirror destinations get a copy of requests that are proxied
+	Mirror []*Mirror `yaml:"mirror,omitempty"`
+	// HostHeader is the Host header sent to the destination, passthrough or
+	// destination
+	HostHeader string `yaml:"hostHeader,omitempty"`
+	// Forwarded sets headers describing the original request, xForwarded,
+	// rfc7239 or both
+	Forwarded string `yaml:"forwarded,omitempty"`
+	// Rewrite rules change the path and query of requests before they are
+	// proxied
+	Rewrite []*Rewrite `yaml:"rewrite,omitempty"`
+	// Headers change the headers of requests and responses
+	Headers []*HeaderRule `yaml:"headers,omitempty"`
+	// Middlewares are registered middleware run only for this route
+	Middlewares []string `yaml:"middlewares,omitempty"`
+	proxy       *httputil.ReverseProxy
+	variants    []*variant
+	mirrors     []*mirror
+	rewrites    []*rewriteRule
+	paths       []*pathRoute
+	matcher     *mux.Router
+	handler     http.Handler
+	pos         position
 }
 
-type RateLimits struct {
-	Internal *RateLimitConfig `yaml:"internal"`
-	External *RateLimitConfig `yaml:"external"`
+func (nh *Namehost) UnmarshalYAML(value *yaml.Node) error {
+	type plain Namehost
+	if err := value.Decode((*plain)(nh)); err != nil {
+		return err
+	}
+	nh.pos.line = value.Line
+	return nil
 }
-type RateLimitConfig struct {
-	Rate  rate.Limit `yaml:"rate"`
-	Burst int        `yaml:"burst"`
+
+// copy returns a copy of the route without any of the state that is built
+// from it, so that it can be changed and rebuilt without affecting requests
+// using the original
+func (nh *Namehost) copy() *Namehost {
+	cp := *nh
+	cp.proxy = nil
+	cp.variants = nil
+	cp.mirrors = nil
+	cp.rewrites = nil
+	cp.paths = nil
+	cp.matcher = nil
+	cp.handler = nil
+	if nh.Redirect != nil {
+		rd := *nh.Redirect
+		cp.Redirect = &rd
+	}
+	if nh.Static != nil {
+		st := *nh.Static
+		st.Index = append([]string(nil), nh.Static.Index...)
+		cp.Static = &st
+	}
+	if nh.Destinations != nil {
+		cp.Destinations = make([]*WeightedDestination, 0, len(nh.Destinations))
+		for _, d := range nh.Destinations {
+			if d == nil {
+				continue
+			}
+			dc := *d
+			cp.Destinations = append(cp.Destinations, &dc)
+		}
+	}
+	if nh.Mirror != nil {
+		cp.Mirror = make([]*Mirror, 0, len(nh.Mirror))
+		for _, m := range nh.Mirror {
+			if m == nil {
+				continue
+			}
+			mc := *m
+			if m.Percent != nil {
+				percent := *m.Percent
+				mc.Percent = &percent
+			}
+			cp.Mirror = append(cp.Mirror, &mc)
+		}
+	}
+	if nh.Rewrite != nil {
+		cp.Rewrite = make([]*Rewrite, 0, len(nh.Rewrite))
+		for _, rw := range nh.Rewrite {
+			if rw == nil {
+				continue
+			}
+			rc := *rw
+			cp.Rewrite = append(cp.Rewrite, &rc)
+		}
+	}
+	if nh.Headers != nil {
+		cp.Headers = make([]*HeaderRule, 0, len(nh.Headers))
+		for _, rule := range nh.Headers {
+			if rule == nil {
+				continue
+			}
+			cp.Headers = append(cp.Headers, rule.copy())
+		}
+	}
+	if nh.Match != nil {
+		cp.Match = make([]*MatchRule, 0, len(nh.Match))
+		for _, rule := range nh.Match {
+			if rule == nil {
+				continue
+			}
+			rc := *rule
+			cp.Match = append(cp.Match, &rc)
+		}
+	}
+	if nh.Paths != nil {
+		cp.Paths = make([]*Path, 0, len(nh.Paths))
+		for _, p := range nh.Paths {
+			if p == nil {
+				continue
+			}
+			pc := *p
+			cp.Paths = append(cp.Paths, &pc)
+		}
+	}
+	return &cp
 }
 
-type Namehost struct {
-	InternalHosts   []string `yaml:"internal"`
-	ExternalHosts   []string `yaml:"external"`
-	DestinationAddr string   `yaml:"destination"`
-	SourcePort      *string  `yaml:"sourcePort"`
-	Always404       bool     `yaml:"always404"`
-	proxy           *httputil.ReverseProxy
+// defaultName is the name given to routes that don't set one
+func (nh *Namehost) defaultName() string {
+	hosts := append(append([]string{}, nh.ExternalHosts...), nh.InternalHosts...)
+	if len(hosts) == 0 {
+		return ""
+	}
+	if hosts[0] == "default" && nh.SourcePort != nil {
+		return "default-" + *nh.SourcePort
+	}
+	return hosts[0]
 }
 
-func New(config *Config) (*NameRouter, error) {
-	var logger *zap.Logger
-	var err error
-	if config.Debug {
-		core := zapcore.NewCore(zapcore.NewConsoleEncoder(zap.NewProductionEncoderConfig()), os.Stdout, zap.DebugLevel)
-		logger = zap.New(core)
-	} else {
-		logger, err = zap.NewProduction()
-		if err != nil {
-			return nil, err
-		}
+// New creates a NameRouter for the given config. Nothing is listened on
+// until Start or Serve is called.
+func New(config *Config, opts ...Option) (*NameRouter, error) {
+	o, err := applyOptions(opts)
+	if err != nil {
+		return nil, err
 	}
 
-	zap.RedirectStdLog(logger)
+	logger := o.logger
+	if logger == nil {
+		if config.Debug {
+			core := zapcore.NewCore(zapcore.NewConsoleEncoder(zap.NewProductionEncoderConfig()), os.Stdout, zap.DebugLevel)
+			logger = zap.New(core)
+		} else {
+			logger, err = zap.NewProduction()
+			if err != nil {
+				return nil, err
+			}
+		}
 
-	n := &NameRouter{
-		nameHosts:    make(map[string]*Namehost),
-		logger:       logger,
-		visitors:     make(map[string]*visitor),
-		config:       config,
-		defaultRoute: make(map[string]*Namehost),
+		zap.RedirectStdLog(logger)
 	}
 
-	n.config.setDefaults()
+	n, err := newNameRouter(config, logger, o)
+	if err != nil {
+		return nil, err
+	}
 
 	n.backgroundCtx, n.backgroundCancel = context.WithCancel(context.Background())
 
 	go n.visitorCleanup(n.backgroundCtx)
 
+	// The middleware chains are part of the route table, so that they
+	// follow config reloads. Each request is served entirely with the route
+	// table that was current when it arrived.
 	router := mux.NewRouter()
-
-	router.PathPrefix("/").HandlerFunc(n.handler)
-
-	mwf := []mux.MiddlewareFunc{
-		n.rateLimiter,
-		n.namehostCtx,
-		n.sourcePort,
-		n.hostHeaderMiddleware,
-	}
-
-	router.Use(mwf...)
+	router.PathPrefix("/").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rt := n.currentRoutes()
+		rt.httpsHandler.ServeHTTP(w, n.withClient(withRoutes(r, rt)))
+	})
 
 	aCert := &autocert.Manager{
-		Cache:      autocert.DirCache("/cert_cache"),
+		Cache:      o.certCache,
 		Prompt:     autocert.AcceptTOS,
 		Email:      config.Email,
-		HostPolicy: autocert.HostWhitelist(getExternalHosts(config.Routes)...),
+		HostPolicy: n.hostPolicy,
 	}
 
 	httpRouter := mux.NewRouter()
-	httpRouter.PathPrefix("/").HandlerFunc(n.handler)
-
-	mwf = append(mwf, n.externalToHTTPSMiddleware)
-	httpRouter.Use(mwf...)
+	httpRouter.PathPrefix("/").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rt := n.currentRoutes()
+		rt.httpHandler.ServeHTTP(w, n.withClient(withRoutes(r, rt)))
+	})
 
-	httpsAddr := ":443"
-	if n.config.HTTPSPort != 0 {
-		httpsAddr = fmt.Sprintf(":%d", n.config.HTTPSPort)
-	}
-
-	httpAddr := ":80"
-	if n.config.HTTPPort != 0 {
-		httpAddr = fmt.Sprintf(":%d", n.config.HTTPPort)
-	}
+	n.router = router
 
 	n.svr = &http.Server{
-		Addr:      httpsAddr,
+		Addr:      n.config.httpsAddr(),
 		Handler:   router,
 		TLSConfig: aCert.TLSConfig(),
 		ConnState: n.captureClosedConnIP,
 	}
 
 	n.httpSvr = &http.Server{
-		Addr:    httpAddr,
+		Addr:    n.config.httpAddr(),
 		Handler: httpRouter,
 	}
 
-	n.healthSvr = &http.Server{
-		Addr: ":9000",
-		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	if o.healthAddr != "" {
+		healthMux := http.NewServeMux()
+		healthMux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 			_, _ = w.Write([]byte("OK"))
-		}),
-	}
-
-	go func() {
-		if err := n.healthSvr.ListenAndServe(); err != nil {
-			n.logger.Error("http health server failed", zap.Error(err))
+		})
+		healthMux.HandleFunc("/config", func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusOK, n.effectiveConfig())
+		})
+		healthMux.HandleFunc("/debug/vars", serveMetrics)
+
+		n.healthSvr = &http.Server{
+			Addr:    o.healthAddr,
+			Handler: healthMux,
 		}
-	}()
+	}
 
-	for _, nh := range config.Routes {
-		if err := n.addNamehost(nh); err != nil {
-			return nil, err
+	if config.Admin != nil && config.Admin.Addr != "" {
+		n.adminSvr = &http.Server{
+			Addr:    config.Admin.Addr,
+			Handler: n.adminRouter(),
 		}
 	}
 
 	return n, nil
 }
 
-func (c *Config) setDefaults() {
-	if c.RateLimits == nil {
-		c.RateLimits = &RateLimits{}
+// newNameRouter validates the config and builds the routes, without
+// creating any servers or background tasks
+func newNameRouter(config *Config, logger *zap.Logger, o *options) (*NameRouter, error) {
+	n := &NameRouter{
+		logger:   logger,
+		visitors: make(map[string]*visitor),
+		config:   config,
+		opts:     o,
 	}
 
-	if c.RateLimits.External == nil {
-		c.RateLimits.External = &RateLimitConfig{
-			Rate:  10,
-			Burst: 10,
-		}
+	n.config.SetDefaults()
+
+	if err := n.config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config:\n%w", err)
 	}
+	n.config.logWarnings(n.logger)
 
-	if c.RateLimits.Internal == nil {
-		c.RateLimits.Internal = &RateLimitConfig{
-			Rate:  1000,
-			Burst: 1000,
-		}
+	var err error
+	n.routes, err = n.buildRouteTable(config)
+	if err != nil {
+		return nil, err
 	}
+	n.routes.logRoutes(n.logger)
+
+	return n, nil
 }
 
+// Start starts all of the configured servers, and blocks until the main
+// HTTP or HTTPS server stops
 func (n *NameRouter) Start() error {
+	if n.healthSvr != nil {
+		go func() {
+			if err := n.healthSvr.ListenAndServe(); err != nil {
+				n.logger.Error("http health server failed", zap.Error(err))
+			}
+		}()
+	}
+
+	if n.adminSvr != nil {
+		go func() {
+			if err := n.adminSvr.ListenAndServe(); err != nil {
+				n.logger.Error("admin server failed", zap.Error(err))
+			}
+		}()
+	}
+
 	if n.config.DoSSL {
 		go func() {
-			if err := n.httpSvr.ListenAndServe(); err != nil {
+			if err := n.serveHTTP(); err != nil {
 				n.logger.Error("http server failed", zap.Error(err))
 			}
 		}()
-		return n.svr.ListenAndServeTLS("", "")
+		if n.opts.httpsListener != nil {
+			return n.svr.ServeTLS(n.opts.httpsListener, "", "")
+		}
+		return listenAndServe(n.svr, n.config.listenAddrs(n.svr.Addr), true)
 	}
-	return n.httpSvr.ListenAndServe()
+	return n.serveHTTP()
 }
 
-func (n *NameRouter) Shutdown(ctx context.Context) {
-	n.backgroundCancel()
-	_ = n.svr.Shutdown(ctx)
-	_ = n.httpSvr.Shutdown(ctx)
-	_ = n.healthSvr.Shutdown(ctx)
-}
+// listenAndServe serves svr on each of addrs until one of them fails. An
+// address without a host listens on every IPv4 and IPv6 address.
+func listenAndServe(svr *http.Server, addrs []string, useTLS bool) error {
+	listeners := make([]net.Listener, 0, len(addrs))
+	for _, addr := range addrs {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return err
+		}
+		listeners = append(listeners, l)
+	}
 
-func (n *NameRouter) addNamehost(nh *Namehost) error {
-	hosts := []string{}
-	hosts = append(hosts, nh.ExternalHosts...)
-	hosts = append(hosts, nh.InternalHosts...)
+	errs := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func() {
+			if useTLS {
+				errs <- svr.ServeTLS(l, "", "")
+				return
+			}
+			errs <- svr.Serve(l)
+		}()
+	}
+	return <-errs
+}
 
-	if nh.DestinationAddr == "" {
-		nh.DestinationAddr = "devnull"
+func (n *NameRouter) serveHTTP() error {
+	if n.opts.httpListener != nil {
+		return n.httpSvr.Serve(n.opts.httpListener)
 	}
+	return listenAndServe(n.httpSvr, n.config.listenAddrs(n.httpSvr.Addr), false)
+}
 
-	for _, host := range hosts {
-		if _, ok := n.nameHosts[host]; ok {
-			return fmt.Errorf("host already registered %s", host)
-		}
+// Handler returns the router as an http.Handler. It does everything the
+// HTTPS server does, except TLS.
+func (n *NameRouter) Handler() http.Handler {
+	return n.router
+}
+
+// Logger returns the logger the router logs to
+func (n *NameRouter) Logger() *zap.Logger {
+	return n.logger
+}
+
+// Serve serves the router's Handler on l until Shutdown is called. l can be
+// any listener, including one from tls.NewListener.
+func (n *NameRouter) Serve(l net.Listener) error {
+	svr := &http.Server{
+		Handler:   n.router,
+		ConnState: n.captureClosedConnIP,
 	}
 
-	if !nh.Always404 {
-		u, err := url.Parse(nh.DestinationAddr)
-		if err != nil {
-			return fmt.Errorf("failed to parse URL for destination host: %w", err)
-		}
-		nh.proxy = httputil.NewSingleHostReverseProxy(u)
+	n.Lock()
+	n.servers = append(n.servers, svr)
+	n.Unlock()
+
+	return svr.Serve(l)
+}
+
+// Shutdown gracefully stops all servers and background tasks
+func (n *NameRouter) Shutdown(ctx context.Context) {
+	n.backgroundCancel()
+	_ = n.svr.Shutdown(ctx)
+	_ = n.httpSvr.Shutdown(ctx)
+	if n.healthSvr != nil {
+		_ = n.healthSvr.Shutdown(ctx)
+	}
+	if n.adminSvr != nil {
+		_ = n.adminSvr.Shutdown(ctx)
 	}
 
-	for _, host := range hosts {
-		n.logger.Info("register host",
-			zap.String("host", host),
-			zap.String("destination", nh.DestinationAddr),
-		)
-		if host == "default" {
-			n.logger.Info("registering default route",
-				zap.String("destination", nh.DestinationAddr),
-			)
-			if nh.SourcePort != nil {
-				n.logger.Info("registering sourcePort default route",
-					zap.String("dest", nh.DestinationAddr),
-					zap.String("source port", *nh.SourcePort),
-				)
-				n.defaultRoute[*nh.SourcePort] = nh
-			} else {
-				n.defaultRoute["80"] = nh
-				n.defaultRoute["443"] = nh
-			}
-		} else {
-			n.nameHosts[host] = nh
-		}
+	n.RLock()
+	servers := n.servers
+	n.RUnlock()
+	for _, svr := range servers {
+		_ = svr.Shutdown(ctx)
 	}
+}
 
+// currentConfig returns the config currently in effect
+func (n *NameRouter) currentConfig() *Config {
+	n.RLock()
+	defer n.RUnlock()
+	return n.config
+}
+
+// currentRoutes returns the route table currently in effect
+func (n *NameRouter) currentRoutes() *routeTable {
+	n.RLock()
+	defer n.RUnlock()
+	return n.routes
+}
+
+// hostPolicy is the autocert HostPolicy. It only allows certificates for
+// hosts matching external hosts in the current route table, including
+// wildcard and regex hosts, so it follows config reloads.
+func (n *NameRouter) hostPolicy(_ context.Context, host string) error {
+	if !n.currentRoutes().isExternalHost(normalizeHost(host)) {
+		return fmt.Errorf("acme/autocert: host %q not configured in HostWhitelist", host)
+	}
 	return nil
 }
 
 func (n *NameRouter) handler(w http.ResponseWriter, r *http.Request) {
+	rt := n.routesFor(r)
 	nh := n.getNamehost(r)
 	if nh == nil {
 		n.logger.Error("missing proxy config",
 			zap.String("request host", r.Host),
 		)
-		dr, ok := n.defaultRoute["80"]
+		dr, ok := rt.defaultRoute["80"]
 		if ok && dr != nil {
 			n.logger.Info("using default route")
-			dr.proxy.ServeHTTP(w, r)
+			dr.handler.ServeHTTP(w, r)
 			return
 		}
 		http.Error(w, "host not configured "+r.Host, http.StatusBadRequest)
 		return
 	}
 
-	if nh.Always404 {
-		http.Error(w, "go away", http.StatusNotFound)
+	if !rt.isExternalHost(normalizeHost(r.Host)) && n.clientClass(r) == clientsExternal {
+		n.denyInternalHost(w, r)
 		return
 	}
 
-	if nh.proxy == nil {
-		n.logger.Error("proxy not configured for host",
-			zap.String("host", r.Host),
+	nh.handler.ServeHTTP(w, r)
+}
+
+// serveNamehost returns the handler for requests matched to a route, before
+// the route's own middleware is applied
+func (n *NameRouter) serveNamehost(nh *Namehost) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var vars headerVars
+		if len(nh.Headers) > 0 {
+			vars = n.headerVars(nh, r)
+			w = &headerWriter{ResponseWriter: w, nh: nh, vars: vars}
+		}
+
+		if nh.Always404 {
+			http.Error(w, "go away", http.StatusNotFound)
+			return
+		}
+
+		if nh.Redirect != nil {
+			location := nh.Redirect.location(r, n.client(r))
+			if location == "" {
+				http.NotFound(w, r)
+				return
+			}
+			n.logger.Info("redirect request",
+				zap.String("Host", r.Host),
+				zap.String("source", r.RemoteAddr),
+				zap.Stringer("client IP", n.client(r).IP),
+				zap.String("client", n.clientClass(r)),
+				zap.String("location", location),
+				zap.String("Request", r.RequestURI),
+			)
+			http.Redirect(w, r, location, nh.Redirect.code())
+			return
+		}
+
+		if nh.Static != nil {
+			n.serveStatic(nh, w, r)
+			return
+		}
+
+		r = n.rewrite(nh, r)
+
+		proxy, dest, variant := nh.proxy, nh.DestinationAddr, ""
+		if rule := nh.matchRule(r); rule != nil {
+			proxy, dest = rule.proxy, rule.DestinationAddr
+		} else if p := nh.matchPath(r.URL.Path); p != nil {
+			proxy, dest = p.proxy, p.DestinationAddr
+			if p.StripPrefix {
+				r = stripPrefix(r, p.Path.Path)
+			}
+		} else if len(nh.variants) > 0 {
+			v := nh.pickVariant(w, r)
+			proxy, dest, variant = v.proxy, v.DestinationAddr, v.name
+			variantRequests.Add(nh.Name+"/"+variant, 1)
+		} else if proxy == nil && (len(nh.paths) > 0 || nh.matcher != nil) {
+			http.NotFound(w, r)
+			return
+		}
+
+		if proxy == nil {
+			n.logger.Error("proxy not configured for host",
+				zap.String("host", r.Host),
+			)
+			http.Error(w, "proxy not configured for host", http.StatusNotImplemented)
+			return
+		}
+
+		n.logger.Info("forward request",
+			zap.String("Host", r.Host),
+			zap.String("source", r.RemoteAddr),
+			zap.Stringer("client IP", n.client(r).IP),
+			zap.String("client", n.clientClass(r)),
+			zap.String("Destination Addr", dest),
+			zap.String("variant", variant),
+			zap.String("Request", r.RequestURI),
 		)
-		http.Error(w, "proxy not configured for host", http.StatusNotImplemented)
-		return
-	}
 
-	n.logger.Info("forward request",
-		zap.String("Host", r.Host),
-		zap.String("source", r.RemoteAddr),
-		zap.String("Destination Addr", nh.DestinationAddr),
-		zap.String("Request", r.RequestURI),
-	)
-	nh.proxy.ServeHTTP(w, r)
+		r = nh.forward(r, dest, n.client(r))
+		if vars != nil {
+			nh.requestHeaders(r, vars)
+		}
+		r, mirrored := n.startMirrors(nh, r)
+		if mirrored != nil {
+			start := time.Now()
+			defer func() { mirrored(time.Since(start)) }()
+		}
+		proxy.ServeHTTP(w, r)
+	})
 }
@@ -0,0 +1,183 @@
+package namerouter
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// testConfig writes a config to a temporary file and loads it
+func testConfig(t *testing.T, config string) *Config {
+	t.Helper()
+	return testConfigFile(t, filepath.Join(t.TempDir(), "config.yaml"), config)
+}
+
+func testConfigFile(t *testing.T, file string, config string) *Config {
+	t.Helper()
+	if err := os.WriteFile(file, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	c, err := LoadConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// testNameRouter builds the routes for a config, without any servers
+func testNameRouter(t *testing.T, config string) *NameRouter {
+	t.Helper()
+	return testNameRouterFile(t, filepath.Join(t.TempDir(), "config.yaml"), config)
+}
+
+func testNameRouterFile(t *testing.T, file string, config string) *NameRouter {
+	t.Helper()
+	n, err := newNameRouter(testConfigFile(t, file, config), zap.NewNop(), &options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// testServer serves a NameRouter's HTTPS handler over plain HTTP
+func testServer(t *testing.T, config *Config) (*NameRouter, *httptest.Server) {
+	t.Helper()
+	n, err := New(config, WithLogger(zap.NewNop()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := httptest.NewServer(n.Handler())
+	t.Cleanup(func() {
+		s.Close()
+		n.Shutdown(context.Background())
+	})
+	return n, s
+}
+
+// routerConfig is a config that routes app.test to a destination. Rate
+// limiting is left out so that tests can send requests as fast as they like.
+func routerConfig(route string) string {
+	return fmt.Sprintf(`middlewares: [namehost, sourcePort, hostHeader, httpsRedirect]
+routes:
+  - name: app
+    internal: [app.test]
+%s`, route)
+}
+
+func get(t *testing.T, s *httptest.Server, host string, path string) (int, string) {
+	t.Helper()
+	return do(t, s, http.MethodGet, host, path, "")
+}
+
+func do(t *testing.T, s *httptest.Server, method string, host string, path string, body string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, s.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Host = host
+	resp, err := s.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(data)
+}
+
+func TestProxy(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s %s", r.Host, r.URL.RequestURI(), r.Header.Get("X-Forwarded-For"))
+	}))
+	defer upstream.Close()
+
+	_, s := testServer(t, testConfig(t, routerConfig(fmt.Sprintf(`    destination: %s
+    rewrite:
+      - match: ^/old/(.*)
+        replace: /new/$1
+`, upstream.URL))))
+
+	tests := []struct {
+		host   string
+		path   string
+		status int
+		body   string
+	}{
+		{host: "app.test", path: "/a?b=c", status: http.StatusOK, body: "app.test /a?b=c 127.0.0.1"},
+		{host: "APP.test:80", path: "/", status: http.StatusOK, body: "APP.test:80 / 127.0.0.1"},
+		{host: "app.test", path: "/old/x", status: http.StatusOK, body: "app.test /new/x 127.0.0.1"},
+		{host: "other.test", path: "/", status: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host+tt.path, func(t *testing.T) {
+			status, body := get(t, s, tt.host, tt.path)
+			if status != tt.status {
+				t.Fatalf("status = %d, want %d", status, tt.status)
+			}
+			if tt.body != "" && body != tt.body {
+				t.Errorf("body = %q, want %q", body, tt.body)
+			}
+		})
+	}
+}
+
+func TestIPv6(t *testing.T) {
+	l, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 isn't available: %v", err)
+	}
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Header.Get("X-Forwarded-For"))
+	}))
+	upstream.Listener.Close()
+	upstream.Listener = l
+	upstream.Start()
+	defer upstream.Close()
+
+	n, err := New(testConfig(t, `middlewares: [namehost]
+routes:
+  - internal: [app.test]
+    destination: `+upstream.URL+`
+`), WithLogger(zap.NewNop()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Shutdown(context.Background())
+	l, err = net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = n.Serve(l) }()
+
+	// ::1 is an internal client, so it can reach app.test
+	req, err := http.NewRequest(http.MethodGet, "http://"+l.Addr().String()+"/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Host = "app.test"
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || string(body) != "::1" {
+		t.Errorf("response = %d %q, want 200 \"::1\"", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,102 @@
+package namerouter
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Option configures a NameRouter
+type Option func(*options) error
+
+type options struct {
+	logger        *zap.Logger
+	certCache     autocert.Cache
+	healthAddr    string
+	httpListener  net.Listener
+	httpsListener net.Listener
+	middleware    []mux.MiddlewareFunc
+}
+
+// WithLogger sets the logger. Without it, a logger is created based on
+// Config.Debug and the standard library logger is redirected to it.
+func WithLogger(logger *zap.Logger) Option {
+	return func(o *options) error {
+		if logger == nil {
+			return fmt.Errorf("logger is nil")
+		}
+		o.logger = logger
+		return nil
+	}
+}
+
+// WithCertCache sets where certificates from Letsencrypt are stored.
+// Without it, certificates are only kept in memory.
+func WithCertCache(cache autocert.Cache) Option {
+	return func(o *options) error {
+		o.certCache = cache
+		return nil
+	}
+}
+
+// WithHealthServer starts a health check server on addr, which responds OK
+// to every request and serves the effective config on /config. There is no
+// health server without it.
+func WithHealthServer(addr string) Option {
+	return func(o *options) error {
+		o.healthAddr = addr
+		return nil
+	}
+}
+
+// WithHTTPListener makes Start serve HTTP on l, instead of listening on the
+// configured httpPort
+func WithHTTPListener(l net.Listener) Option {
+	return func(o *options) error {
+		o.httpListener = l
+		return nil
+	}
+}
+
+// WithHTTPSListener makes Start serve HTTPS on l, instead of listening on
+// the configured httpsPort
+func WithHTTPSListener(l net.Listener) Option {
+	return func(o *options) error {
+		o.httpsListener = l
+		return nil
+	}
+}
+
+// WithMiddleware adds middleware that runs after the global middleware
+// chain, just before a request is routed
+func WithMiddleware(mw ...mux.MiddlewareFunc) Option {
+	return func(o *options) error {
+		o.middleware = append(o.middleware, mw...)
+		return nil
+	}
+}
+
+func applyOptions(opts []Option) (*options, error) {
+	o := &options{}
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return nil, err
+		}
+	}
+	return o, nil
+}
+
+// certCacheName describes the cert cache for display
+func (o *options) certCacheName() string {
+	switch c := o.certCache.(type) {
+	case nil:
+		return ""
+	case autocert.DirCache:
+		return string(c)
+	default:
+		return fmt.Sprintf("%T", c)
+	}
+}
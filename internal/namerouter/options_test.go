@@ -0,0 +1,63 @@
+package namerouter
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestOptions(t *testing.T) {
+	config := routerConfig("    destination: " + testUpstream(t, "ok") + "\n")
+
+	if _, err := New(testConfig(t, config), WithLogger(nil)); err == nil {
+		t.Error("New() with a nil logger succeeded, want an error")
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var seen []string
+	n, err := New(testConfig(t, config),
+		WithLogger(zap.NewNop()),
+		WithHTTPListener(l),
+		WithMiddleware(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = append(seen, r.URL.Path)
+				next.ServeHTTP(w, r)
+			})
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- n.Start() }()
+
+	req, err := http.NewRequest(http.MethodGet, "http://"+l.Addr().String()+"/a", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Host = "app.test"
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if len(seen) != 1 || seen[0] != "/a" {
+		t.Errorf("middleware saw %v, want [/a]", seen)
+	}
+
+	n.Shutdown(context.Background())
+	if err := <-done; !errors.Is(err, http.ErrServerClosed) {
+		t.Errorf("Start() = %v, want %v", err, http.ErrServerClosed)
+	}
+}
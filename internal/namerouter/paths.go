@@ -0,0 +1,102 @@
+package namerouter
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Path sends requests for part of a route's URL space to a different
+// destination than the route's own
+type Path struct {
+	// Path is the path to match. Unless Exact is set it is a prefix, which
+	// matches the path itself and everything below it.
+	Path string `yaml:"path"`
+	// Exact only matches requests for Path itself
+	Exact           bool   `yaml:"exact,omitempty"`
+	DestinationAddr string `yaml:"destination"`
+	// StripPrefix removes Path from the request before it is proxied
+	StripPrefix bool `yaml:"stripPrefix,omitempty"`
+}
+
+// pathRoute is a Path with the proxy built from it
+type pathRoute struct {
+	*Path
+	proxy *httputil.ReverseProxy
+}
+
+// matches reports whether a request path is matched by p. Prefixes only
+// match whole path segments, so /api matches /api/users but not /apiv2.
+func (p *Path) matches(path string) bool {
+	if path == p.Path {
+		return true
+	}
+	if p.Exact {
+		return false
+	}
+	prefix := p.Path
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return strings.HasPrefix(path, prefix)
+}
+
+// buildPaths creates the proxies for a route's paths, ordered so that the
+// first match for a request is the most specific one: exact paths first,
+// then the longest prefix
+func buildPaths(paths []*Path) ([]*pathRoute, error) {
+	routes := make([]*pathRoute, 0, len(paths))
+	for _, p := range paths {
+		if p == nil {
+			continue
+		}
+		u, err := url.Parse(p.DestinationAddr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse URL for path %s destination: %w", p.Path, err)
+		}
+		routes = append(routes, &pathRoute{
+			Path:  p,
+			proxy: httputil.NewSingleHostReverseProxy(u),
+		})
+	}
+
+	sort.SliceStable(routes, func(i, j int) bool {
+		if routes[i].Exact != routes[j].Exact {
+			return routes[i].Exact
+		}
+		return len(routes[i].Path.Path) > len(routes[j].Path.Path)
+	})
+
+	return routes, nil
+}
+
+// matchPath returns the most specific path of the route matching a request
+// path, or nil if there is none
+func (nh *Namehost) matchPath(path string) *pathRoute {
+	for _, p := range nh.paths {
+		if p.matches(path) {
+			return p
+		}
+	}
+	return nil
+}
+
+// stripPrefix returns a copy of r with prefix removed from its path
+func stripPrefix(r *http.Request, prefix string) *http.Request {
+	r2 := r.Clone(r.Context())
+	r2.URL.Path = ensureLeadingSlash(strings.TrimPrefix(r.URL.Path, prefix))
+	if r.URL.RawPath != "" {
+		r2.URL.RawPath = ensureLeadingSlash(strings.TrimPrefix(r.URL.RawPath, prefix))
+	}
+	return r2
+}
+
+func ensureLeadingSlash(p string) string {
+	if !strings.HasPrefix(p, "/") {
+		return "/" + p
+	}
+	return p
+}
@@ -0,0 +1,87 @@
+package namerouter
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+// pathUpstream responds with its name and the path it received
+func pathUpstream(t *testing.T, name string) string {
+	t.Helper()
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s", name, r.URL.RequestURI())
+	}))
+	t.Cleanup(s.Close)
+	return s.URL
+}
+
+func TestPaths(t *testing.T) {
+	_, s := testServer(t, testConfig(t, routerConfig(`    destination: `+pathUpstream(t, "root")+`
+    paths:
+      - path: /api
+        destination: `+pathUpstream(t, "api")+`
+      - path: /api/v2/
+        destination: `+pathUpstream(t, "v2")+`
+        stripPrefix: true
+      - path: /health
+        exact: true
+        destination: `+pathUpstream(t, "health")+`
+`)))
+
+	tests := map[string]string{
+		"/":              "root /",
+		"/api":           "api /api",
+		"/api/users?a=b": "api /api/users?a=b",
+		"/apiv2":         "root /apiv2",
+		"/api/v2/users":  "v2 /users",
+		"/api/v2/":       "v2 /",
+		"/health":        "health /health",
+		"/health/deep":   "root /health/deep",
+	}
+	for path, want := range tests {
+		t.Run(path, func(t *testing.T) {
+			if _, body := get(t, s, "app.test", path); body != want {
+				t.Errorf("body = %q, want %q", body, want)
+			}
+		})
+	}
+}
+
+func TestPathsWithoutDestination(t *testing.T) {
+	_, s := testServer(t, testConfig(t, routerConfig(`    paths:
+      - path: /api
+        destination: `+pathUpstream(t, "api")+`
+`)))
+	if status, _ := get(t, s, "app.test", "/api/x"); status != http.StatusOK {
+		t.Errorf("status = %d, want %d", status, http.StatusOK)
+	}
+	if status, _ := get(t, s, "app.test", "/other"); status != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", status, http.StatusNotFound)
+	}
+}
+
+func TestPathErrors(t *testing.T) {
+	dir := t.TempDir()
+	file := writeConfig(t, dir, "c.yaml", `routes:
+  - internal: [app.local]
+    paths:
+      - path: api
+        destination: http://127.0.0.1:8080
+      - path: /b
+        destination: http://127.0.0.1:8081
+      - path: /b
+        destination: http://127.0.0.1:8082
+      - path: /c
+`)
+	want := []string{
+		"c.yaml:2: path \"api\" must start with /",
+		"c.yaml:2: path \"/b\" is listed more than once",
+		"c.yaml:2: path \"/c\" is missing a destination",
+	}
+	if got := validationErrors(t, dir, file); !slices.Equal(got, want) {
+		t.Errorf("ValidateFile() =\n%q\nwant\n%q", got, want)
+	}
+}
@@ -0,0 +1,319 @@
+package namerouter
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// routeFile is the new contents of a config file whose routes changed
+type routeFile struct {
+	file string
+	data []byte
+}
+
+// persistRoutes works out the contents of the files the routes in config
+// were loaded from, with their routes sections rewritten to match. Global
+// settings and routes that haven't changed are kept as they were, so
+// comments and ${} references in them are kept. Changed routes only have
+// their changed fields replaced, and new routes are added to the main config
+// file. The routes in config are given their positions in the new files, so
+// config must not be in use yet.
+func persistRoutes(old *Config, config *Config) ([]routeFile, error) {
+	main := config.pos.file
+	if main == "" {
+		return nil, fmt.Errorf("config was not loaded from a file")
+	}
+
+	files := []string{main}
+	byFile := map[string][]*Namehost{
+		main: {},
+	}
+	addFile := func(file string) {
+		if _, ok := byFile[file]; !ok {
+			files = append(files, file)
+			byFile[file] = []*Namehost{}
+		}
+	}
+
+	// Files whose last route was removed still need to be rewritten
+	previous := make(map[position]*Namehost)
+	for _, nh := range old.Routes {
+		if nh.pos.file != "" {
+			addFile(nh.pos.file)
+		}
+		if nh.pos.line > 0 {
+			previous[nh.pos] = nh
+		}
+	}
+
+	for _, nh := range config.Routes {
+		file := nh.pos.file
+		if file == "" {
+			file = main
+		}
+		addFile(file)
+		byFile[file] = append(byFile[file], nh)
+	}
+
+	out := make([]routeFile, 0, len(files))
+	for _, file := range files {
+		data, err := writeRoutes(file, byFile[file], previous)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, routeFile{file: file, data: data})
+	}
+
+	return out, nil
+}
+
+// writeRouteFiles writes files prepared by persistRoutes
+func writeRouteFiles(files []routeFile) error {
+	for _, f := range files {
+		if err := os.WriteFile(f.file, f.data, 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeRoutes returns a YAML config file with its routes replaced. Routes
+// whose position still points at a route in the file keep their original
+// node, with any fields that changed since previous replaced.
+func writeRoutes(file string, routes []*Namehost, previous map[position]*Namehost) ([]byte, error) {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yml", ".yaml":
+	default:
+		return nil, fmt.Errorf("persisting routes is only supported for YAML files, not %s", file)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", file, err)
+	}
+
+	if root.Kind == 0 {
+		root.Kind = yaml.DocumentNode
+	}
+	if len(root.Content) == 0 {
+		root.Content = []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}
+	}
+	doc := root.Content[0]
+	if doc.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s is not a YAML mapping", file)
+	}
+
+	var routesNode *yaml.Node
+	for i := 0; i+1 < len(doc.Content); i += 2 {
+		if doc.Content[i].Value == "routes" {
+			routesNode = doc.Content[i+1]
+			break
+		}
+	}
+	if routesNode == nil {
+		routesNode = &yaml.Node{}
+		doc.Content = append(doc.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "routes"},
+			routesNode,
+		)
+	}
+
+	original := make(map[int]*yaml.Node)
+	if routesNode.Kind == yaml.SequenceNode {
+		for _, item := range routesNode.Content {
+			original[item.Line] = item
+		}
+	}
+
+	items := make([]*yaml.Node, 0, len(routes))
+	for _, nh := range routes {
+		item, err := encodeRoute(nh)
+		if err != nil {
+			return nil, err
+		}
+		if orig, ok := original[nh.pos.line]; ok && nh.pos.line > 0 && orig.Kind == yaml.MappingNode {
+			prev, ok := previous[position{file: file, line: nh.pos.line}]
+			if !ok {
+				return nil, fmt.Errorf("route %s no longer matches %s", nh.Name, nh.pos)
+			}
+			before, err := encodeRoute(prev)
+			if err != nil {
+				return nil, err
+			}
+			if err := patchRoute(orig, before, item); err != nil {
+				return nil, fmt.Errorf("route %s: %w", nh.Name, err)
+			}
+			item = orig
+		}
+		items = append(items, item)
+	}
+	*routesNode = yaml.Node{
+		Kind:        yaml.SequenceNode,
+		Tag:         "!!seq",
+		Content:     items,
+		HeadComment: routesNode.HeadComment,
+		LineComment: routesNode.LineComment,
+		FootComment: routesNode.FootComment,
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&root); err != nil {
+		return nil, fmt.Errorf("failed to encode %s: %w", file, err)
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+
+	// Routes will live at new lines in the file, record them so that the
+	// next change can find them again
+	var written yaml.Node
+	if err := yaml.Unmarshal(buf.Bytes(), &written); err != nil {
+		return nil, err
+	}
+	if doc := documentContent(&written); doc != nil {
+		for i := 0; i+1 < len(doc.Content); i += 2 {
+			if doc.Content[i].Value != "routes" {
+				continue
+			}
+			for j, item := range doc.Content[i+1].Content {
+				if j < len(routes) {
+					routes[j].pos = position{file: file, line: item.Line}
+				}
+			}
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// encodeRoute encodes a route as a YAML node. Its values are the expanded
+// ones, so any ${ in them is escaped to keep it from being expanded when the
+// file is loaded again.
+func encodeRoute(nh *Namehost) (*yaml.Node, error) {
+	node := &yaml.Node{}
+	if err := node.Encode(nh); err != nil {
+		return nil, fmt.Errorf("failed to encode route %s: %w", nh.Name, err)
+	}
+	escapeReferences(node)
+	return node, nil
+}
+
+func escapeReferences(node *yaml.Node) {
+	if node.Kind == yaml.ScalarNode {
+		node.Value = strings.ReplaceAll(node.Value, "${", "$${")
+	}
+	for _, c := range node.Content {
+		escapeReferences(c)
+	}
+}
+
+// hasReference reports whether any value in node has a ${} reference
+func hasReference(node *yaml.Node) bool {
+	if node.Kind == yaml.ScalarNode {
+		v := node.Value
+		for {
+			i := strings.Index(v, "${")
+			if i < 0 {
+				return false
+			}
+			if i == 0 || v[i-1] != '$' {
+				return true
+			}
+			v = v[i+2:]
+		}
+	}
+	for _, c := range node.Content {
+		if hasReference(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// patchRoute changes the fields of a route's original node that differ
+// between before and after, which are the route encoded before and after the
+// change. Fields whose original value has a ${} reference can't be changed,
+// since the expanded value would be written to the file in its place.
+func patchRoute(orig, before, after *yaml.Node) error {
+	for i := 0; i+1 < len(after.Content); i += 2 {
+		key, value := after.Content[i].Value, after.Content[i+1]
+		if nodesEqual(mappingValue(before, key), value) {
+			continue
+		}
+		if err := setMappingValue(orig, key, value); err != nil {
+			return err
+		}
+	}
+	for i := 0; i+1 < len(before.Content); i += 2 {
+		key := before.Content[i].Value
+		if mappingValue(after, key) != nil {
+			continue
+		}
+		if err := setMappingValue(orig, key, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// setMappingValue sets or, when value is nil, removes a key of a mapping
+func setMappingValue(node *yaml.Node, key string, value *yaml.Node) error {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value != key {
+			continue
+		}
+		if value != nil && hasReference(node.Content[i+1]) {
+			return fmt.Errorf("%s: %w", key, ErrRouteReference)
+		}
+		if value == nil {
+			node.Content = append(node.Content[:i], node.Content[i+2:]...)
+		} else {
+			value.LineComment = node.Content[i+1].LineComment
+			node.Content[i+1] = value
+		}
+		return nil
+	}
+	if value != nil {
+		node.Content = append(node.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key},
+			value,
+		)
+	}
+	return nil
+}
+
+func nodesEqual(a, b *yaml.Node) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	ab, err := yaml.Marshal(a)
+	if err != nil {
+		return false
+	}
+	bb, err := yaml.Marshal(b)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(ab, bb)
+}
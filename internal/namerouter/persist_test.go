@@ -0,0 +1,133 @@
+package namerouter
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const persistConfig = `admin:
+  addr: 127.0.0.1:0
+  token: test
+  persist: true
+routes:
+  # the app
+  - name: app
+    internal: [app.example.com]
+    destination: ${file:upstream}
+    headers:
+      - request:
+          set:
+            Authorization: Bearer ${file:token}
+`
+
+func TestPersistKeepsReferences(t *testing.T) {
+	dir := t.TempDir()
+	for name, value := range map[string]string{"upstream": "http://127.0.0.1:1", "token": "s3cret"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(value), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	file := filepath.Join(dir, "config.yaml")
+	n := testNameRouterFile(t, file, persistConfig)
+
+	err := n.UpdateRoute("app", func(nh *Namehost) error {
+		nh.InternalHosts = append(nh.InternalHosts, "www.example.com")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := readFile(t, file)
+	for _, want := range []string{"# the app", "${file:upstream}", "${file:token}", "www.example.com"} {
+		if !strings.Contains(data, want) {
+			t.Errorf("persisted config is missing %q:\n%s", want, data)
+		}
+	}
+	if strings.Contains(data, "s3cret") {
+		t.Errorf("persisted config has the expanded secret:\n%s", data)
+	}
+
+	err = n.UpdateRoute("app", func(nh *Namehost) error {
+		nh.Headers = nil
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data := readFile(t, file); strings.Contains(data, "${file:token}") || !strings.Contains(data, "${file:upstream}") {
+		t.Errorf("removed field wasn't removed:\n%s", data)
+	}
+}
+
+func TestPersistRefusesToReplaceReferences(t *testing.T) {
+	dir := t.TempDir()
+	for name, value := range map[string]string{"upstream": "http://127.0.0.1:1", "token": "s3cret"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(value), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	file := filepath.Join(dir, "config.yaml")
+	n := testNameRouterFile(t, file, persistConfig)
+
+	for _, fn := range []func(nh *Namehost){
+		func(nh *Namehost) { nh.DestinationAddr = "http://127.0.0.1:2" },
+		func(nh *Namehost) { nh.Headers[0].Request.Set["X-Extra"] = "1" },
+	} {
+		err := n.UpdateRoute("app", func(nh *Namehost) error {
+			fn(nh)
+			return nil
+		})
+		if !errors.Is(err, ErrRouteReference) {
+			t.Fatalf("UpdateRoute() = %v, want %v", err, ErrRouteReference)
+		}
+	}
+	if data := readFile(t, file); data != persistConfig {
+		t.Errorf("config was changed:\n%s", data)
+	}
+	nh, _ := n.currentRoutes().match("app.example.com")
+	if _, set := nh.Headers[0].Request.Set["X-Extra"]; set || nh.DestinationAddr != "http://127.0.0.1:1" {
+		t.Error("refused change was applied")
+	}
+}
+
+func TestPersistEscapesNewRoutes(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.yaml")
+	n := testNameRouterFile(t, file, `admin:
+  addr: 127.0.0.1:0
+  token: test
+  persist: true
+routes: []
+`)
+
+	err := n.AddRoute(&Namehost{
+		InternalHosts:   []string{"new.example.com"},
+		DestinationAddr: "http://127.0.0.1:1",
+		Headers: []*HeaderRule{{
+			Request: &HeaderOps{Set: map[string]string{"X-Literal": "${NOT_A_REF}"}},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := LoadConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := config.Routes[0].Headers[0].Request.Set["X-Literal"]; got != "${NOT_A_REF}" {
+		t.Errorf("reloaded header = %q, want ${NOT_A_REF}", got)
+	}
+}
+
+func readFile(t *testing.T, file string) string {
+	t.Helper()
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
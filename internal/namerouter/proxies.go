@@ -0,0 +1,176 @@
+package namerouter
+
+import (
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// Headers trusted proxies can report the client IP in
+const (
+	// TrustedProxyHeaderXForwardedFor takes the client IP from
+	// X-Forwarded-For. It is the default.
+	TrustedProxyHeaderXForwardedFor = "X-Forwarded-For"
+	// TrustedProxyHeaderXRealIP takes the client IP from X-Real-IP
+	TrustedProxyHeaderXRealIP = "X-Real-IP"
+	// TrustedProxyHeaderForwarded takes the client IP from the Forwarded
+	// header from RFC 7239
+	TrustedProxyHeaderForwarded = "Forwarded"
+)
+
+func (c *Config) trustedProxyHeader() string {
+	switch http.CanonicalHeaderKey(c.TrustedProxyHeader) {
+	case "", http.CanonicalHeaderKey(TrustedProxyHeaderXForwardedFor):
+		return TrustedProxyHeaderXForwardedFor
+	case http.CanonicalHeaderKey(TrustedProxyHeaderXRealIP):
+		return TrustedProxyHeaderXRealIP
+	case http.CanonicalHeaderKey(TrustedProxyHeaderForwarded):
+		return TrustedProxyHeaderForwarded
+	}
+	return c.TrustedProxyHeader
+}
+
+// isTrustedProxy reports whether ip is on one of the trusted proxy networks
+func (rt *routeTable) isTrustedProxy(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range rt.trustedProxies {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveClient fills in the client behind any trusted proxies. For requests
+// from a trusted proxy, the hops reported in the trusted proxy header are
+// walked from the right, skipping other trusted proxies, and the first
+// untrusted one is the client. The scheme and host of the original request
+// are also taken from the proxy's headers.
+func (rt *routeTable) resolveClient(c *Client, h http.Header) {
+	c.IP = c.RemoteIP
+	if !rt.isTrustedProxy(c.RemoteIP) {
+		return
+	}
+
+	c.proto, c.host, c.port = reportedRequest(h, rt.trustedProxyHeader)
+
+	chain := reportedHops(h, rt.trustedProxyHeader)
+	var hops []net.IP
+	for i := len(chain) - 1; i >= 0; i-- {
+		hop := parseHop(chain[i])
+		if hop == nil {
+			break
+		}
+		hops = append(hops, hop)
+		if !rt.isTrustedProxy(hop) {
+			break
+		}
+	}
+	if len(hops) == 0 {
+		return
+	}
+
+	slices.Reverse(hops)
+	c.IP = hops[0]
+	c.hops = hops
+	c.via = rt.trustedProxyHeader
+}
+
+// reportedHops returns the client addresses reported in a request's trusted
+// proxy header, oldest first
+func reportedHops(h http.Header, header string) []string {
+	var hops []string
+	switch header {
+	case TrustedProxyHeaderXRealIP:
+		if v := h.Get(header); v != "" {
+			hops = append(hops, v)
+		}
+	case TrustedProxyHeaderForwarded:
+		for _, elem := range forwardedElements(h) {
+			hops = append(hops, forwardedParam(elem, "for"))
+		}
+	default:
+		for _, v := range h.Values(header) {
+			hops = append(hops, strings.Split(v, ",")...)
+		}
+	}
+	return hops
+}
+
+// reportedRequest returns the scheme, host and port of the original request
+// reported by a trusted proxy. The last value of each header is used, which
+// is the one set by the proxy the request came from. Invalid values are
+// ignored.
+func reportedRequest(h http.Header, header string) (proto, host, port string) {
+	if header == TrustedProxyHeaderForwarded {
+		if elems := forwardedElements(h); len(elems) > 0 {
+			last := elems[len(elems)-1]
+			proto, host = forwardedParam(last, "proto"), forwardedParam(last, "host")
+		}
+	} else {
+		proto = lastValue(h, "X-Forwarded-Proto")
+		host = lastValue(h, "X-Forwarded-Host")
+		port = lastValue(h, "X-Forwarded-Port")
+	}
+
+	proto = strings.ToLower(proto)
+	if proto != "http" && proto != "https" {
+		proto = ""
+	}
+	if !httpguts.ValidHostHeader(host) {
+		host = ""
+	}
+	if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+		port = ""
+	}
+	return proto, host, port
+}
+
+// lastValue returns the last of the comma separated values of a header
+func lastValue(h http.Header, name string) string {
+	values := h.Values(name)
+	if len(values) == 0 {
+		return ""
+	}
+	parts := strings.Split(values[len(values)-1], ",")
+	return strings.TrimSpace(parts[len(parts)-1])
+}
+
+// forwardedElements returns the elements of the Forwarded headers, oldest
+// first
+func forwardedElements(h http.Header) []string {
+	var elems []string
+	for _, v := range h.Values(TrustedProxyHeaderForwarded) {
+		elems = append(elems, strings.Split(v, ",")...)
+	}
+	return elems
+}
+
+// forwardedParam returns a parameter of a Forwarded element, or an empty
+// string if it doesn't have it
+func forwardedParam(elem string, name string) string {
+	for _, pair := range strings.Split(elem, ";") {
+		key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if ok && strings.EqualFold(key, name) {
+			return strings.Trim(value, `"`)
+		}
+	}
+	return ""
+}
+
+// parseHop parses a reported client address, which can have a port and
+// IPv6 addresses can be in brackets. Unknown and obfuscated addresses
+// return nil.
+func parseHop(s string) net.IP {
+	s = strings.TrimSpace(s)
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	return net.ParseIP(strings.Trim(s, "[]"))
+}
@@ -0,0 +1,279 @@
+package namerouter
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func testRouteTable(t *testing.T, trusted []string, header string) *routeTable {
+	t.Helper()
+	nets, err := parseNetworks(trusted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &routeTable{
+		trustedProxies:     nets,
+		trustedProxyHeader: (&Config{TrustedProxyHeader: header}).trustedProxyHeader(),
+	}
+}
+
+func TestResolveClient(t *testing.T) {
+	tests := []struct {
+		name    string
+		remote  string
+		header  string
+		headers map[string][]string
+		ip      string
+		hops    []string
+	}{
+		{
+			name:    "untrusted remote ignores headers",
+			remote:  "203.0.113.1",
+			headers: map[string][]string{"X-Forwarded-For": {"10.1.2.3"}},
+			ip:      "203.0.113.1",
+		},
+		{
+			name:   "trusted remote without headers",
+			remote: "10.0.0.1",
+			ip:     "10.0.0.1",
+		},
+		{
+			name:    "x-forwarded-for",
+			remote:  "10.0.0.1",
+			headers: map[string][]string{"X-Forwarded-For": {"203.0.113.9"}},
+			ip:      "203.0.113.9",
+			hops:    []string{"203.0.113.9"},
+		},
+		{
+			name:    "spoofed entries left of the client are ignored",
+			remote:  "10.0.0.1",
+			headers: map[string][]string{"X-Forwarded-For": {"10.1.2.3, 198.51.100.4, 203.0.113.9"}},
+			ip:      "203.0.113.9",
+			hops:    []string{"203.0.113.9"},
+		},
+		{
+			name:    "trusted hops are skipped",
+			remote:  "10.0.0.1",
+			headers: map[string][]string{"X-Forwarded-For": {"203.0.113.9, 10.0.0.2", "10.0.0.3"}},
+			ip:      "203.0.113.9",
+			hops:    []string{"203.0.113.9", "10.0.0.2", "10.0.0.3"},
+		},
+		{
+			name:    "all trusted uses the leftmost",
+			remote:  "10.0.0.1",
+			headers: map[string][]string{"X-Forwarded-For": {"10.0.0.5, 10.0.0.2"}},
+			ip:      "10.0.0.5",
+			hops:    []string{"10.0.0.5", "10.0.0.2"},
+		},
+		{
+			name:    "invalid hop stops the walk",
+			remote:  "10.0.0.1",
+			headers: map[string][]string{"X-Forwarded-For": {"203.0.113.9, garbage, 10.0.0.2"}},
+			ip:      "10.0.0.2",
+			hops:    []string{"10.0.0.2"},
+		},
+		{
+			name:    "ports and brackets",
+			remote:  "10.0.0.1",
+			headers: map[string][]string{"X-Forwarded-For": {"[2001:db8::9]:1234"}},
+			ip:      "2001:db8::9",
+			hops:    []string{"2001:db8::9"},
+		},
+		{
+			name:   "only the configured header is read",
+			remote: "10.0.0.1",
+			header: TrustedProxyHeaderXRealIP,
+			headers: map[string][]string{
+				"X-Forwarded-For": {"10.1.2.3"},
+				"X-Real-Ip":       {"203.0.113.9"},
+			},
+			ip:   "203.0.113.9",
+			hops: []string{"203.0.113.9"},
+		},
+		{
+			name:    "x-real-ip ignores x-forwarded-for",
+			remote:  "10.0.0.1",
+			header:  TrustedProxyHeaderXRealIP,
+			headers: map[string][]string{"X-Forwarded-For": {"10.1.2.3"}},
+			ip:      "10.0.0.1",
+		},
+		{
+			name:    "forwarded",
+			remote:  "10.0.0.1",
+			header:  TrustedProxyHeaderForwarded,
+			headers: map[string][]string{"Forwarded": {`for=10.1.2.3, for="[2001:db8::9]:443";proto=https`}},
+			ip:      "2001:db8::9",
+			hops:    []string{"2001:db8::9"},
+		},
+		{
+			name:    "forwarded element without for stops the walk",
+			remote:  "10.0.0.1",
+			header:  TrustedProxyHeaderForwarded,
+			headers: map[string][]string{"Forwarded": {"for=203.0.113.9, proto=https"}},
+			ip:      "10.0.0.1",
+		},
+		{
+			name:    "forwarded unknown",
+			remote:  "10.0.0.1",
+			header:  TrustedProxyHeaderForwarded,
+			headers: map[string][]string{"Forwarded": {"for=unknown"}},
+			ip:      "10.0.0.1",
+		},
+	}
+
+	rts := map[string]*routeTable{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt, ok := rts[tt.header]
+			if !ok {
+				rt = testRouteTable(t, []string{"10.0.0.0/24"}, tt.header)
+				rts[tt.header] = rt
+			}
+
+			c := &Client{RemoteIP: net.ParseIP(tt.remote)}
+			rt.resolveClient(c, http.Header(tt.headers))
+
+			if c.IP.String() != tt.ip {
+				t.Errorf("IP = %s, want %s", c.IP, tt.ip)
+			}
+			var hops []string
+			for _, hop := range c.hops {
+				hops = append(hops, hop.String())
+			}
+			if !slices.Equal(hops, tt.hops) {
+				t.Errorf("hops = %v, want %v", hops, tt.hops)
+			}
+		})
+	}
+}
+
+func TestReportedRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		headers map[string][]string
+		proto   string
+		host    string
+		port    string
+	}{
+		{
+			name: "x-forwarded",
+			headers: map[string][]string{
+				"X-Forwarded-Proto": {"https"},
+				"X-Forwarded-Host":  {"example.com"},
+				"X-Forwarded-Port":  {"443"},
+			},
+			proto: "https",
+			host:  "example.com",
+			port:  "443",
+		},
+		{
+			name:    "last value wins",
+			headers: map[string][]string{"X-Forwarded-Proto": {"https, http"}},
+			proto:   "http",
+		},
+		{
+			name: "invalid values are ignored",
+			headers: map[string][]string{
+				"X-Forwarded-Proto": {"gopher"},
+				"X-Forwarded-Host":  {"bad host"},
+				"X-Forwarded-Port":  {"99999"},
+			},
+		},
+		{
+			name:    "forwarded uses the last element",
+			header:  TrustedProxyHeaderForwarded,
+			headers: map[string][]string{"Forwarded": {"for=1.2.3.4;proto=http;host=evil.com, for=203.0.113.9;proto=HTTPS;host=\"example.com\""}},
+			proto:   "https",
+			host:    "example.com",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := (&Config{TrustedProxyHeader: tt.header}).trustedProxyHeader()
+			proto, host, port := reportedRequest(http.Header(tt.headers), header)
+			if proto != tt.proto || host != tt.host || port != tt.port {
+				t.Errorf("got %q %q %q, want %q %q %q", proto, host, port, tt.proto, tt.host, tt.port)
+			}
+		})
+	}
+}
+
+func TestTrustedProxyHeader(t *testing.T) {
+	tests := map[string]string{
+		"":                TrustedProxyHeaderXForwardedFor,
+		"x-forwarded-for": TrustedProxyHeaderXForwardedFor,
+		"X-Real-IP":       TrustedProxyHeaderXRealIP,
+		"x-real-ip":       TrustedProxyHeaderXRealIP,
+		"forwarded":       TrustedProxyHeaderForwarded,
+		"X-Foo":           "X-Foo",
+	}
+	for in, want := range tests {
+		if got := (&Config{TrustedProxyHeader: in}).trustedProxyHeader(); got != want {
+			t.Errorf("trustedProxyHeader(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestTrustedProxyRedirects(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("proxied"))
+	}))
+	defer upstream.Close()
+
+	n, _ := testServer(t, testConfig(t, `middlewares: [namehost, httpsRedirect]
+trustedProxies: [127.0.0.1/32]
+internalNetworks: [10.0.0.0/8]
+routes:
+  - external: [example.com]
+    redirect:
+      preset: apexToWww
+  - external: [app.example.com]
+    destination: `+upstream.URL+`
+`))
+	// The proxy terminates TLS and sends everything to the HTTP listener
+	s := httptest.NewServer(n.httpSvr.Handler)
+	defer s.Close()
+	client := s.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	tests := []struct {
+		name     string
+		host     string
+		proto    string
+		status   int
+		location string
+	}{
+		{name: "preset over https", host: "example.com", proto: "https", status: http.StatusFound, location: "https://www.example.com/a?b=c"},
+		{name: "preset over http", host: "example.com", proto: "http", status: http.StatusFound, location: "https://example.com/a?b=c"},
+		{name: "proxied over https", host: "app.example.com", proto: "https", status: http.StatusOK},
+		{name: "redirected to https", host: "app.example.com", proto: "http", status: http.StatusFound, location: "https://app.example.com/a?b=c"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, s.URL+"/a?b=c", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Host = tt.host
+			req.Header.Set("X-Forwarded-For", "203.0.113.9")
+			req.Header.Set("X-Forwarded-Proto", tt.proto)
+			req.Header.Set("X-Forwarded-Host", tt.host)
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.status || resp.Header.Get("Location") != tt.location {
+				t.Errorf("response = %d %q, want %d %q", resp.StatusCode, resp.Header.Get("Location"), tt.status, tt.location)
+			}
+		})
+	}
+}
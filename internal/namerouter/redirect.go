@@ -0,0 +1,146 @@
+package namerouter
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// Redirect presets
+const (
+	// RedirectWWWToApex redirects www.example.com to example.com
+	RedirectWWWToApex = "wwwToApex"
+	// RedirectApexToWWW redirects example.com to www.example.com
+	RedirectApexToWWW = "apexToWww"
+)
+
+// Redirect makes a route redirect clients instead of proxying. It can be
+// written as just the URL to redirect to.
+type Redirect struct {
+	// URL is where clients are redirected to
+	URL string `yaml:"url,omitempty"`
+	// Preset redirects to a host derived from the request host, keeping the
+	// path and query. It is used instead of URL.
+	Preset string `yaml:"preset,omitempty"`
+	// Status is the redirect status code, 302 by default
+	Status int `yaml:"status,omitempty"`
+	// PreservePath appends the request path to the URL's path
+	PreservePath bool `yaml:"preservePath,omitempty"`
+	// PreserveQuery adds the request query to the URL's query
+	PreserveQuery bool `yaml:"preserveQuery,omitempty"`
+}
+
+func (rd *Redirect) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		rd.URL = value.Value
+		return nil
+	}
+	type plain Redirect
+	return value.Decode((*plain)(rd))
+}
+
+// code returns the status code to redirect with
+func (rd *Redirect) code() int {
+	if rd.Status == 0 {
+		return http.StatusFound
+	}
+	return rd.Status
+}
+
+// location returns where a request is redirected to. It is empty when a
+// preset doesn't apply to the request host. Presets keep the scheme and use
+// the host of the original request, as reported by a trusted proxy.
+func (rd *Redirect) location(r *http.Request, client *Client) string {
+	if rd.Preset != "" {
+		host := normalizeHost(client.requestHost(r))
+		switch rd.Preset {
+		case RedirectWWWToApex:
+			if !strings.HasPrefix(host, "www.") {
+				return ""
+			}
+			host = strings.TrimPrefix(host, "www.")
+		case RedirectApexToWWW:
+			if strings.HasPrefix(host, "www.") {
+				return ""
+			}
+			host = "www." + host
+		}
+		return client.scheme(r) + "://" + host + r.URL.RequestURI()
+	}
+
+	u, err := url.Parse(rd.URL)
+	if err != nil {
+		return ""
+	}
+	if rd.PreservePath {
+		u.Path = strings.TrimSuffix(u.Path, "/") + ensureLeadingSlash(r.URL.Path)
+		u.RawPath = ""
+	}
+	if rd.PreserveQuery && r.URL.RawQuery != "" {
+		if u.RawQuery != "" {
+			u.RawQuery += "&"
+		}
+		u.RawQuery += r.URL.RawQuery
+	}
+	return u.String()
+}
+
+func (rd *Redirect) validate(nh *Namehost) ValidationErrors {
+	errs := ValidationErrors{}
+
+	switch rd.Status {
+	case 0, http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		errs = append(errs, nh.pos.errorf("redirect status %d must be 301, 302, 307 or 308", rd.Status))
+	}
+
+	switch rd.Preset {
+	case "":
+		if rd.URL == "" {
+			errs = append(errs, nh.pos.errorf("redirect needs a url or a preset"))
+		} else if err := validateRedirectURL(rd.URL); err != nil {
+			errs = append(errs, nh.pos.errorf("%s", err.Error()))
+		}
+	case RedirectWWWToApex, RedirectApexToWWW:
+		if rd.URL != "" {
+			errs = append(errs, nh.pos.errorf("redirect can't have both a url and a preset"))
+		}
+		if rd.PreservePath || rd.PreserveQuery {
+			errs = append(errs, nh.pos.errorf("redirect presets always preserve the path and query"))
+		}
+		for _, host := range append(append([]string{}, nh.ExternalHosts...), nh.InternalHosts...) {
+			if host == "default" || isWildcardHost(host) || isRegexHost(host) {
+				continue
+			}
+			www := strings.HasPrefix(normalizeConfigHost(host), "www.")
+			if rd.Preset == RedirectWWWToApex && !www {
+				errs = append(errs, nh.pos.errorf("host %q can't use redirect preset %s, it doesn't start with www.", host, rd.Preset))
+			}
+			if rd.Preset == RedirectApexToWWW && www {
+				errs = append(errs, nh.pos.errorf("host %q can't use redirect preset %s, it already starts with www.", host, rd.Preset))
+			}
+		}
+	default:
+		errs = append(errs, nh.pos.errorf("unknown redirect preset %q, must be %s or %s", rd.Preset, RedirectWWWToApex, RedirectApexToWWW))
+	}
+
+	return errs
+}
+
+// validateRedirectURL checks that a redirect URL is absolute
+func validateRedirectURL(target string) error {
+	u, err := url.Parse(target)
+	if err != nil {
+		return fmt.Errorf("invalid redirect url %q: %w", target, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("redirect url %q must be an http or https URL", target)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("redirect url %q is missing a host", target)
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package namerouter
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestRedirectLocation(t *testing.T) {
+	tests := []struct {
+		name     string
+		redirect Redirect
+		url      string
+		tls      bool
+		client   Client
+		want     string
+	}{
+		{
+			name:     "url",
+			redirect: Redirect{URL: "https://new.example.com/landing"},
+			url:      "http://old.example.com/a?b=c",
+			want:     "https://new.example.com/landing",
+		},
+		{
+			name:     "preserve path",
+			redirect: Redirect{URL: "https://new.example.com/base/", PreservePath: true},
+			url:      "http://old.example.com/a/b?c=d",
+			want:     "https://new.example.com/base/a/b",
+		},
+		{
+			name:     "preserve query",
+			redirect: Redirect{URL: "https://new.example.com/?from=old", PreserveQuery: true},
+			url:      "http://old.example.com/a?b=c",
+			want:     "https://new.example.com/?from=old&b=c",
+		},
+		{
+			name:     "apex to www",
+			redirect: Redirect{Preset: RedirectApexToWWW},
+			url:      "http://Example.com/a?b=c",
+			want:     "http://www.example.com/a?b=c",
+		},
+		{
+			name:     "apex to www over tls",
+			redirect: Redirect{Preset: RedirectApexToWWW},
+			url:      "https://example.com:443/a",
+			tls:      true,
+			want:     "https://www.example.com/a",
+		},
+		{
+			name:     "apex to www doesn't apply to www",
+			redirect: Redirect{Preset: RedirectApexToWWW},
+			url:      "http://www.example.com/a",
+		},
+		{
+			name:     "www to apex",
+			redirect: Redirect{Preset: RedirectWWWToApex},
+			url:      "http://www.example.com/a",
+			want:     "http://example.com/a",
+		},
+		{
+			name:     "www to apex doesn't apply to apex",
+			redirect: Redirect{Preset: RedirectWWWToApex},
+			url:      "http://example.com/a",
+		},
+		{
+			name:     "preset behind a tls terminating proxy",
+			redirect: Redirect{Preset: RedirectApexToWWW},
+			url:      "http://10.0.0.5:8080/a",
+			client:   Client{proto: "https", host: "example.com"},
+			want:     "https://www.example.com/a",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			if !tt.tls {
+				r.TLS = nil
+			} else if r.TLS == nil {
+				r.TLS = &tls.ConnectionState{}
+			}
+			client := tt.client
+			if got := tt.redirect.location(r, &client); got != tt.want {
+				t.Errorf("location() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedirectRoute(t *testing.T) {
+	_, s := testServer(t, testConfig(t, `middlewares: [namehost]
+routes:
+  - internal: [old.test]
+    redirect:
+      url: http://new.test/
+      status: 301
+      preservePath: true
+  - internal: [apex.test]
+    redirect:
+      preset: apexToWww
+`))
+	client := s.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	tests := []struct {
+		host     string
+		path     string
+		status   int
+		location string
+	}{
+		{host: "old.test", path: "/a", status: http.StatusMovedPermanently, location: "http://new.test/a"},
+		{host: "apex.test", path: "/a?b=c", status: http.StatusFound, location: "http://www.apex.test/a?b=c"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, s.URL+tt.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Host = tt.host
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.status || resp.Header.Get("Location") != tt.location {
+				t.Errorf("response = %d %q, want %d %q", resp.StatusCode, resp.Header.Get("Location"), tt.status, tt.location)
+			}
+		})
+	}
+}
+
+func TestRedirectErrors(t *testing.T) {
+	dir := t.TempDir()
+	file := writeConfig(t, dir, "c.yaml", `routes:
+  - internal: [a.local]
+    redirect:
+      preset: sideways
+  - internal: [b.local]
+    redirect:
+      url: /relative
+      status: 200
+  - internal: [c.local]
+    redirect:
+      url: https://x.test/
+      preset: apexToWww
+  - internal: [d.local]
+    redirect: {}
+`)
+	want := []string{
+		"c.yaml:2: unknown redirect preset \"sideways\", must be wwwToApex or apexToWww",
+		"c.yaml:5: redirect status 200 must be 301, 302, 307 or 308",
+		"c.yaml:5: redirect url \"/relative\" must be an http or https URL",
+		"c.yaml:9: redirect can't have both a url and a preset",
+		"c.yaml:13: redirect needs a url or a preset",
+	}
+	if got := validationErrors(t, dir, file); !slices.Equal(got, want) {
+		t.Errorf("ValidateFile() =\n%q\nwant\n%q", got, want)
+	}
+}
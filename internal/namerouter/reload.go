@@ -0,0 +1,233 @@
+package namerouter
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"go.uber.org/zap"
+)
+
+// reloadDebounce is how long to wait for a burst of file events to settle
+// before reloading. Editors and configmap updates usually produce several
+// events for a single change.
+const reloadDebounce = 500 * time.Millisecond
+
+// Reload validates the given config and, if it is valid, atomically swaps
+// it in. Requests that are already in flight finish using the previous
+// routes. On failure the running config is left untouched.
+func (n *NameRouter) Reload(config *Config) error {
+	n.reloadLock.Lock()
+	defer n.reloadLock.Unlock()
+
+	return n.reload(config)
+}
+
+// reload does the work of Reload. reloadLock must be held.
+func (n *NameRouter) reload(config *Config) error {
+	if config == nil {
+		return fmt.Errorf("config is nil")
+	}
+
+	rt, err := n.prepareReload(config)
+	if err != nil {
+		n.logger.Error("config reload failed, keeping current config",
+			zap.Error(err),
+		)
+		return err
+	}
+	config.logWarnings(n.logger)
+
+	n.Lock()
+	oldConfig := n.config
+	oldRoutes := n.routes
+	n.config = config
+	n.routes = rt
+	limitsChanged := !oldConfig.RateLimits.Internal.equal(config.RateLimits.Internal) ||
+		!oldConfig.RateLimits.External.equal(config.RateLimits.External) ||
+		oldConfig.RateLimits.IPv4Prefix != config.RateLimits.IPv4Prefix ||
+		oldConfig.RateLimits.IPv6Prefix != config.RateLimits.IPv6Prefix
+	networksChanged := !slices.Equal(oldConfig.internalNetworks(), config.internalNetworks())
+	if limitsChanged || networksChanged {
+		// Limiters are created with the rates and client classification in
+		// effect at the time, so drop them and let new ones be created on
+		// the next request.
+		n.visitors = make(map[string]*visitor)
+	}
+	n.Unlock()
+
+	rt.logDiff(n.logger, oldRoutes)
+	rt.logOverlaps(n.logger)
+
+	if limitsChanged {
+		n.logger.Info("reload: rate limits changed",
+			zap.Float64("internal rate", float64(config.RateLimits.Internal.Rate)),
+			zap.Int("internal burst", config.RateLimits.Internal.Burst),
+			zap.Float64("external rate", float64(config.RateLimits.External.Rate)),
+			zap.Int("external burst", config.RateLimits.External.Burst),
+			zap.Int("ipv4 prefix", config.RateLimits.IPv4Prefix),
+			zap.Int("ipv6 prefix", config.RateLimits.IPv6Prefix),
+		)
+	}
+
+	if networksChanged {
+		n.logger.Info("reload: internal networks changed",
+			zap.Strings("internal networks", config.internalNetworks()),
+		)
+	}
+
+	if !slices.Equal(oldConfig.TrustedProxies, config.TrustedProxies) ||
+		oldConfig.trustedProxyHeader() != config.trustedProxyHeader() {
+		n.logger.Info("reload: trusted proxies changed",
+			zap.Strings("trusted proxies", config.TrustedProxies),
+			zap.String("trusted proxy header", config.trustedProxyHeader()),
+		)
+	}
+
+	if !slices.Equal(oldConfig.globalMiddlewares(), config.globalMiddlewares()) {
+		n.logger.Info("reload: middleware chain changed",
+			zap.Strings("middlewares", config.globalMiddlewares()),
+		)
+	}
+
+	if oldConfig.HTTPPort != config.HTTPPort || oldConfig.HTTPSPort != config.HTTPSPort ||
+		oldConfig.DoSSL != config.DoSSL || oldConfig.Email != config.Email || oldConfig.Debug != config.Debug ||
+		oldConfig.adminAddr() != config.adminAddr() || !slices.Equal(oldConfig.ListenAddresses, config.ListenAddresses) {
+		n.logger.Warn("reload: listener, TLS, admin address and logging settings require a restart to take effect")
+	}
+
+	n.logger.Info("config reloaded")
+
+	return nil
+}
+
+// prepareReload validates a config and builds its route table, without
+// changing anything in use. A panic while doing so is returned as an error,
+// so that a config the checks miss can't take down a running router.
+func (n *NameRouter) prepareReload(config *Config) (rt *routeTable, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			rt, err = nil, fmt.Errorf("failed to build routes: %v", r)
+		}
+	}()
+
+	config.SetDefaults()
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	return n.buildRouteTable(config)
+}
+
+// ReloadFrom loads a config using the given loader and reloads it
+func (n *NameRouter) ReloadFrom(load func() (*Config, error)) error {
+	config, err := load()
+	if err != nil {
+		n.logger.Error("config reload failed, keeping current config",
+			zap.Error(err),
+		)
+		return err
+	}
+
+	return n.Reload(config)
+}
+
+// WatchConfig watches the given config file, and any files it includes,
+// and reloads the config using load whenever they change. It blocks until
+// ctx is cancelled.
+func (n *NameRouter) WatchConfig(ctx context.Context, file string, load func() (*Config, error)) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create config watcher: %w", err)
+	}
+	defer watcher.Close()
+
+	var patterns []string
+	watched := make(map[string]bool)
+
+	// Watch directories rather than files, so that editors which replace
+	// the file, Kubernetes configmap symlink swaps and new include files
+	// are all seen. The include patterns can change on reload, so this is
+	// redone after every reload.
+	watch := func() {
+		n.RLock()
+		includes := n.config.includePatterns()
+		n.RUnlock()
+
+		patterns = []string{}
+		for _, p := range append([]string{file}, includes...) {
+			abs, err := filepath.Abs(p)
+			if err != nil {
+				n.logger.Error("failed to resolve config path", zap.String("path", p), zap.Error(err))
+				continue
+			}
+			patterns = append(patterns, abs)
+
+			dir := filepath.Dir(abs)
+			if watched[dir] {
+				continue
+			}
+			if err := watcher.Add(dir); err != nil {
+				n.logger.Error("failed to watch config directory", zap.String("dir", dir), zap.Error(err))
+				continue
+			}
+			watched[dir] = true
+			n.logger.Info("watching config for changes",
+				zap.String("dir", dir),
+			)
+		}
+	}
+
+	matches := func(name string) bool {
+		if filepath.Base(name) == "..data" {
+			return true
+		}
+		for _, p := range patterns {
+			if ok, _ := filepath.Match(p, filepath.Clean(name)); ok {
+				return true
+			}
+		}
+		return false
+	}
+
+	watch()
+	if len(watched) == 0 {
+		return fmt.Errorf("failed to watch config file %s", file)
+	}
+
+	var reload <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if event.Has(fsnotify.Chmod) || !matches(event.Name) {
+				continue
+			}
+			n.logger.Debug("config file event",
+				zap.String("event", event.String()),
+			)
+			reload = time.After(reloadDebounce)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			n.logger.Error("config watcher error", zap.Error(err))
+		case <-reload:
+			reload = nil
+			n.logger.Info("config changed, reloading",
+				zap.String("file", file),
+			)
+			if err := n.ReloadFrom(load); err == nil {
+				watch()
+			}
+		}
+	}
+}
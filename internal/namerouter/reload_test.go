@@ -0,0 +1,167 @@
+package namerouter
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReload(t *testing.T) {
+	upstreams := []string{testUpstream(t, "one"), testUpstream(t, "two")}
+	configs := make([]string, len(upstreams))
+	for i, u := range upstreams {
+		configs[i] = routerConfig("    destination: " + u + "\n")
+	}
+
+	n, s := testServer(t, testConfig(t, configs[0]))
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	errs := make(chan error, 1)
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				req, _ := http.NewRequest(http.MethodGet, s.URL, nil)
+				req.Host = "app.test"
+				resp, err := s.Client().Do(req)
+				if err != nil {
+					errs <- err
+					return
+				}
+				data, _ := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusOK || (string(data) != "one" && string(data) != "two") {
+					select {
+					case errs <- fmt.Errorf("response during reload = %d %q", resp.StatusCode, data):
+					default:
+					}
+					return
+				}
+			}
+		}()
+	}
+
+	for i := range 20 {
+		if err := n.Reload(testConfig(t, configs[(i+1)%2])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(done)
+	wg.Wait()
+	select {
+	case err := <-errs:
+		t.Fatal(err)
+	default:
+	}
+
+	if _, body := get(t, s, "app.test", "/"); body != "one" {
+		t.Errorf("body after reloads = %q, want one", body)
+	}
+
+	// An invalid config leaves the current routes in place
+	invalid := &Config{Routes: []*Namehost{{InternalHosts: []string{"app.test"}, DestinationAddr: "ftp://127.0.0.1"}}}
+	if err := n.Reload(invalid); err == nil {
+		t.Fatal("invalid config was reloaded")
+	}
+	if _, body := get(t, s, "app.test", "/"); body != "one" {
+		t.Errorf("body after failed reload = %q, want one", body)
+	}
+}
+
+func TestReloadRejectsEmptyRoute(t *testing.T) {
+	upstream := testUpstream(t, "one")
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	n, s := testServer(t, testConfigFile(t, file, routerConfig("    destination: "+upstream+"\n")))
+
+	// A null route is loaded from the file, and only caught when reloading
+	writeConfig(t, filepath.Dir(file), "config.yaml", routerConfig("    destination: "+upstream+"\n  -\n"))
+	if err := n.ReloadFrom(func() (*Config, error) { return LoadConfig(file) }); err == nil {
+		t.Fatal("config with an empty route was reloaded")
+	}
+	if status, body := get(t, s, "app.test", "/"); status != http.StatusOK || body != "one" {
+		t.Errorf("response after failed reload = %d %q, want 200 one", status, body)
+	}
+
+	// A panic while building the routes also leaves them in place
+	if _, ok := registeredMiddleware("reloadTestPanics"); !ok {
+		if err := RegisterMiddleware("reloadTestPanics", func(http.Handler) http.Handler { panic("boom") }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	config := testConfig(t, routerConfig("    destination: "+upstream+"\n    middlewares: [reloadTestPanics]\n"))
+	if err := n.Reload(config); err == nil {
+		t.Fatal("config that panics was reloaded")
+	}
+	if status, body := get(t, s, "app.test", "/"); status != http.StatusOK || body != "one" {
+		t.Errorf("response after failed reload = %d %q, want 200 one", status, body)
+	}
+}
+
+func TestWatchConfig(t *testing.T) {
+	one, two := testUpstream(t, "one"), testUpstream(t, "two")
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.yaml")
+	n, s := testServer(t, testConfigFile(t, file, routerConfig("    destination: "+one+"\n")))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	watching := make(chan error, 1)
+	go func() {
+		watching <- n.WatchConfig(ctx, file, func() (*Config, error) { return LoadConfig(file) })
+	}()
+	defer func() {
+		cancel()
+		if err := <-watching; err != nil {
+			t.Error(err)
+		}
+	}()
+
+	// Give the watcher time to start before changing the file
+	time.Sleep(100 * time.Millisecond)
+	writeConfig(t, dir, "config.yaml", routerConfig("    destination: "+two+"\n"))
+	waitFor(t, func() bool {
+		_, body := get(t, s, "app.test", "/")
+		return body == "two"
+	})
+
+	// Saving a config with an empty route keeps the running one
+	writeConfig(t, dir, "config.yaml", routerConfig("    destination: "+one+"\n  -\n"))
+	time.Sleep(2 * reloadDebounce)
+	if status, body := get(t, s, "app.test", "/"); status != http.StatusOK || body != "two" {
+		t.Errorf("response after invalid config was saved = %d %q, want 200 two", status, body)
+	}
+}
+
+// testUpstream starts a destination that responds with body
+func testUpstream(t *testing.T, body string) string {
+	t.Helper()
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(s.Close)
+	return s.URL
+}
+
+// waitFor polls cond until it is true, failing the test after 5 seconds
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,147 @@
+package namerouter
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// Rewrite changes the path and query of requests before they are proxied.
+// Match is a regular expression matched against the request path. When it
+// matches, the path is replaced with Replace, which can refer to capture
+// groups as $1 or $name. A query in Replace is added before the request's
+// query, unless Replace ends with ?, which drops the request's query.
+type Rewrite struct {
+	Match   string `yaml:"match"`
+	Replace string `yaml:"replace"`
+	// Last stops any further rules from being applied when this one matches
+	Last bool `yaml:"last,omitempty"`
+}
+
+// rewriteRule is a Rewrite with its expression compiled
+type rewriteRule struct {
+	*Rewrite
+	re *regexp.Regexp
+}
+
+func buildRewrites(rewrites []*Rewrite) ([]*rewriteRule, error) {
+	rules := make([]*rewriteRule, 0, len(rewrites))
+	for _, rw := range rewrites {
+		if rw == nil {
+			continue
+		}
+		re, err := regexp.Compile(rw.Match)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rewrite %q: %w", rw.Match, err)
+		}
+		rules = append(rules, &rewriteRule{Rewrite: rw, re: re})
+	}
+	return rules, nil
+}
+
+// rewriteStep is a rule that was applied to a URL and what it produced
+type rewriteStep struct {
+	rule int
+	uri  string
+}
+
+// rewriteURI applies the route's rewrite rules to an escaped path and raw
+// query, in order. It returns the rewritten request URI and each rule that
+// was applied.
+func (nh *Namehost) rewriteURI(path, query string) (string, []rewriteStep) {
+	var steps []rewriteStep
+	for i, rule := range nh.rewrites {
+		m := rule.re.FindStringSubmatchIndex(path)
+		if m == nil {
+			continue
+		}
+		replaced := string(rule.re.ExpandString(nil, rule.Replace, path, m))
+
+		newPath, newQuery, hasQuery := strings.Cut(replaced, "?")
+		switch {
+		case !hasQuery:
+		case newQuery == "":
+			query = ""
+		case query == "":
+			query = newQuery
+		default:
+			query = newQuery + "&" + query
+		}
+		path = newPath
+
+		steps = append(steps, rewriteStep{rule: i, uri: requestURI(path, query)})
+		if rule.Last {
+			break
+		}
+	}
+	return requestURI(path, query), steps
+}
+
+func requestURI(path, query string) string {
+	if query == "" {
+		return path
+	}
+	return path + "?" + query
+}
+
+// rewrite returns the request with the route's rewrite rules applied to its
+// URL
+func (n *NameRouter) rewrite(nh *Namehost, r *http.Request) *http.Request {
+	if len(nh.rewrites) == 0 {
+		return r
+	}
+
+	original := r.URL.RequestURI()
+	uri, steps := nh.rewriteURI(r.URL.EscapedPath(), r.URL.RawQuery)
+	if len(steps) == 0 {
+		return r
+	}
+
+	u, err := url.ParseRequestURI(uri)
+	if err != nil {
+		n.logger.Error("invalid rewritten request",
+			zap.String("Host", r.Host),
+			zap.String("original", original),
+			zap.String("rewritten", uri),
+			zap.Error(err),
+		)
+		return r
+	}
+
+	n.logger.Debug("rewrite request",
+		zap.String("Host", r.Host),
+		zap.String("original", original),
+		zap.String("rewritten", uri),
+	)
+
+	r2 := r.Clone(r.Context())
+	r2.URL.Path = u.Path
+	r2.URL.RawPath = u.RawPath
+	r2.URL.RawQuery = u.RawQuery
+	return r2
+}
+
+func (nh *Namehost) validateRewrites() ValidationErrors {
+	errs := ValidationErrors{}
+
+	for i, rw := range nh.Rewrite {
+		if rw == nil {
+			errs = append(errs, nh.pos.errorf("empty rewrite"))
+			continue
+		}
+		if rw.Match == "" {
+			errs = append(errs, nh.pos.errorf("rewrite %d is missing a match", i+1))
+		} else if _, err := regexp.Compile(rw.Match); err != nil {
+			errs = append(errs, nh.pos.errorf("rewrite %d: invalid match %q: %s", i+1, rw.Match, err.Error()))
+		}
+		if !strings.HasPrefix(rw.Replace, "/") {
+			errs = append(errs, nh.pos.errorf("rewrite %d: replace %q must start with /", i+1, rw.Replace))
+		}
+	}
+
+	return errs
+}
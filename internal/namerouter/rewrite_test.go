@@ -0,0 +1,136 @@
+package namerouter
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestRewriteURI(t *testing.T) {
+	tests := []struct {
+		name     string
+		rewrites []*Rewrite
+		path     string
+		query    string
+		want     string
+		steps    int
+	}{
+		{
+			name:     "no match",
+			rewrites: []*Rewrite{{Match: "^/api/", Replace: "/"}},
+			path:     "/web/x",
+			want:     "/web/x",
+		},
+		{
+			name:     "numbered groups",
+			rewrites: []*Rewrite{{Match: "^/api/v([0-9]+)/(.*)$", Replace: "/v$1/$2"}},
+			path:     "/api/v2/users",
+			want:     "/v2/users",
+			steps:    1,
+		},
+		{
+			name:     "named groups",
+			rewrites: []*Rewrite{{Match: "^/u/(?P<user>[^/]+)$", Replace: "/users/${user}/profile"}},
+			path:     "/u/ann",
+			want:     "/users/ann/profile",
+			steps:    1,
+		},
+		{
+			name:     "group next to text",
+			rewrites: []*Rewrite{{Match: "^/(.*)$", Replace: "/${1}_old"}},
+			path:     "/page",
+			want:     "/page_old",
+			steps:    1,
+		},
+		{
+			name:     "the whole path is replaced",
+			rewrites: []*Rewrite{{Match: "^/old", Replace: "/new"}},
+			path:     "/old/x",
+			want:     "/new",
+			steps:    1,
+		},
+		{
+			name:     "query is kept",
+			rewrites: []*Rewrite{{Match: "^/old", Replace: "/new"}},
+			path:     "/old",
+			query:    "a=1",
+			want:     "/new?a=1",
+			steps:    1,
+		},
+		{
+			name:     "query is added before the request's",
+			rewrites: []*Rewrite{{Match: "^/item/([0-9]+)$", Replace: "/item?id=$1"}},
+			path:     "/item/7",
+			query:    "a=1",
+			want:     "/item?id=7&a=1",
+			steps:    1,
+		},
+		{
+			name:     "trailing question mark drops the query",
+			rewrites: []*Rewrite{{Match: "^/old", Replace: "/new?"}},
+			path:     "/old",
+			query:    "a=1",
+			want:     "/new",
+			steps:    1,
+		},
+		{
+			name: "rules apply in order",
+			rewrites: []*Rewrite{
+				{Match: "^/a/(.*)$", Replace: "/b/$1"},
+				{Match: "^/b/(.*)$", Replace: "/c/$1"},
+			},
+			path:  "/a/x",
+			want:  "/c/x",
+			steps: 2,
+		},
+		{
+			name: "last stops",
+			rewrites: []*Rewrite{
+				{Match: "^/a/(.*)$", Replace: "/b/$1", Last: true},
+				{Match: "^/b/(.*)$", Replace: "/c/$1"},
+			},
+			path:  "/a/x",
+			want:  "/b/x",
+			steps: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rewrites, err := buildRewrites(tt.rewrites)
+			if err != nil {
+				t.Fatal(err)
+			}
+			nh := &Namehost{rewrites: rewrites}
+
+			got, steps := nh.rewriteURI(tt.path, tt.query)
+			if got != tt.want {
+				t.Errorf("rewriteURI(%q, %q) = %q, want %q", tt.path, tt.query, got, tt.want)
+			}
+			if len(steps) != tt.steps {
+				t.Errorf("rewriteURI(%q, %q) applied %d rules, want %d", tt.path, tt.query, len(steps), tt.steps)
+			}
+		})
+	}
+}
+
+func TestRewriteErrors(t *testing.T) {
+	dir := t.TempDir()
+	file := writeConfig(t, dir, "c.yaml", `routes:
+  - internal: [a.local]
+    destination: http://127.0.0.1:8080
+    rewrite:
+      - match: "^/(api"
+        replace: /
+      - replace: /x
+      - match: ^/a
+        replace: b
+`)
+	want := []string{
+		"c.yaml:2: rewrite 1: invalid match \"^/(api\": error parsing regexp: missing closing ): `^/(api`",
+		"c.yaml:2: rewrite 2 is missing a match",
+		"c.yaml:2: rewrite 3: replace \"b\" must start with /",
+	}
+	if got := validationErrors(t, dir, file); !slices.Equal(got, want) {
+		t.Errorf("ValidateFile() =\n%q\nwant\n%q", got, want)
+	}
+}
@@ -0,0 +1,388 @@
+package namerouter
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"reflect"
+	"slices"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// routeTable is an immutable snapshot of the configured routes. A new
+// table is built on every config change and swapped in as a whole, so
+// in-flight requests keep using the table they started with.
+type routeTable struct {
+	// nameHosts and externalHosts only have exact hosts
+	nameHosts     map[string]*Namehost
+	wildcardHosts map[string]*hostPattern
+	regexHosts    []*hostPattern
+	defaultRoute  map[string]*Namehost
+	externalHosts map[string]struct{}
+	// internalNetworks are the networks of internal clients
+	internalNetworks []*net.IPNet
+	// trustedProxies are the networks of proxies whose forwarded headers
+	// are used to find the client IP
+	trustedProxies []*net.IPNet
+	// trustedProxyHeader is the header trusted proxies report the client IP
+	// in
+	trustedProxyHeader string
+	// internalHostResponse is how external clients requesting an internal
+	// host are answered
+	internalHostResponse string
+	// httpsHandler and httpHandler are the global middleware chains for
+	// each listener, ending in the route handler
+	httpsHandler http.Handler
+	httpHandler  http.Handler
+}
+
+func newRouteTable() *routeTable {
+	return &routeTable{
+		nameHosts:     make(map[string]*Namehost),
+		wildcardHosts: make(map[string]*hostPattern),
+		defaultRoute:  make(map[string]*Namehost),
+		externalHosts: make(map[string]struct{}),
+	}
+}
+
+func (n *NameRouter) buildRouteTable(config *Config) (*routeTable, error) {
+	rt := newRouteTable()
+
+	nets, err := parseNetworks(config.internalNetworks())
+	if err != nil {
+		return nil, err
+	}
+	rt.internalNetworks = nets
+
+	rt.trustedProxies, err = parseNetworks(config.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	rt.trustedProxyHeader = config.trustedProxyHeader()
+	rt.internalHostResponse = config.internalHostResponse()
+
+	for i, nh := range config.Routes {
+		if nh == nil {
+			return nil, fmt.Errorf("route %d is empty", i+1)
+		}
+		if err := rt.addNamehost(nh); err != nil {
+			return nil, err
+		}
+
+		h, err := routeChain(nh.Middlewares, n.serveNamehost(nh))
+		if err != nil {
+			return nil, fmt.Errorf("route %s: %w", nh.Name, err)
+		}
+		nh.handler = h
+	}
+
+	rt.httpsHandler, err = n.buildChain(config.globalMiddlewares(), true, http.HandlerFunc(n.handler))
+	if err != nil {
+		return nil, err
+	}
+	rt.httpHandler, err = n.buildChain(config.globalMiddlewares(), false, http.HandlerFunc(n.handler))
+	if err != nil {
+		return nil, err
+	}
+
+	return rt, nil
+}
+
+func (rt *routeTable) addNamehost(nh *Namehost) error {
+	hosts := []string{}
+	hosts = append(hosts, nh.ExternalHosts...)
+	hosts = append(hosts, nh.InternalHosts...)
+
+	registered := rt.hosts()
+	for _, host := range hosts {
+		if _, ok := registered[normalizeConfigHost(host)]; ok {
+			return fmt.Errorf("host already registered %s", host)
+		}
+	}
+
+	if !nh.Always404 && nh.Redirect == nil && nh.Static == nil {
+		if nh.DestinationAddr != "" {
+			u, err := url.Parse(nh.DestinationAddr)
+			if err != nil {
+				return fmt.Errorf("failed to parse URL for destination host: %w", err)
+			}
+			nh.proxy = httputil.NewSingleHostReverseProxy(u)
+		}
+
+		variants, err := buildVariants(nh.Destinations)
+		if err != nil {
+			return err
+		}
+		nh.variants = variants
+
+		nh.mirrors, err = buildMirrors(nh.Name, nh.Mirror)
+		if err != nil {
+			return err
+		}
+
+		nh.rewrites, err = buildRewrites(nh.Rewrite)
+		if err != nil {
+			return err
+		}
+
+		paths, err := buildPaths(nh.Paths)
+		if err != nil {
+			return err
+		}
+		nh.paths = paths
+
+		nh.matcher, err = buildMatchRouter(nh.Match)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i, host := range hosts {
+		host = normalizeConfigHost(host)
+		external := i < len(nh.ExternalHosts)
+		switch {
+		case host == "default":
+			if nh.SourcePort != nil {
+				rt.defaultRoute[*nh.SourcePort] = nh
+			} else {
+				rt.defaultRoute["80"] = nh
+				rt.defaultRoute["443"] = nh
+			}
+		case isWildcardHost(host):
+			rt.wildcardHosts[strings.TrimPrefix(host, "*.")] = &hostPattern{
+				host:     host,
+				nh:       nh,
+				external: external,
+			}
+		case isRegexHost(host):
+			re, err := compileHostRegex(host)
+			if err != nil {
+				return err
+			}
+			rt.regexHosts = append(rt.regexHosts, &hostPattern{
+				host:     host,
+				re:       re,
+				nh:       nh,
+				external: external,
+			})
+		default:
+			rt.nameHosts[host] = nh
+			if external {
+				rt.externalHosts[host] = struct{}{}
+			}
+		}
+	}
+
+	return nil
+}
+
+func (rt *routeTable) logRoutes(logger *zap.Logger) {
+	for host, nh := range rt.hosts() {
+		logger.Info("register host",
+			zap.String("host", host),
+			zap.String("destination", nh.DestinationAddr),
+		)
+	}
+	for port, nh := range rt.defaultRoute {
+		logger.Info("registering default route",
+			zap.String("dest", nh.DestinationAddr),
+			zap.String("source port", port),
+		)
+	}
+	rt.logOverlaps(logger)
+}
+
+// logOverlaps warns about hosts that are matched by more than one route
+func (rt *routeTable) logOverlaps(logger *zap.Logger) {
+	for _, msg := range rt.overlaps() {
+		logger.Warn("overlapping hosts",
+			zap.String("detail", msg),
+		)
+	}
+}
+
+// logDiff logs the hosts that were added, removed or changed between two
+// route tables
+func (rt *routeTable) logDiff(logger *zap.Logger, old *routeTable) {
+	hosts, oldHosts := rt.hosts(), old.hosts()
+	for host, nh := range hosts {
+		prev, ok := oldHosts[host]
+		switch {
+		case !ok:
+			logger.Info("reload: added host",
+				zap.String("host", host),
+				zap.String("destination", nh.DestinationAddr),
+			)
+		case prev.DestinationAddr != nh.DestinationAddr || prev.Always404 != nh.Always404 ||
+			(prev.Redirect == nil) != (nh.Redirect == nil) || (nh.Redirect != nil && *prev.Redirect != *nh.Redirect) ||
+			!slices.EqualFunc(prev.Paths, nh.Paths, func(a, b *Path) bool { return *a == *b }) ||
+			!reflect.DeepEqual(prev.Match, nh.Match) || !reflect.DeepEqual(prev.Static, nh.Static) ||
+			!reflect.DeepEqual(prev.Destinations, nh.Destinations) || prev.StickyCookie != nh.StickyCookie ||
+			!reflect.DeepEqual(prev.Mirror, nh.Mirror) || !reflect.DeepEqual(prev.Headers, nh.Headers) ||
+			!reflect.DeepEqual(prev.Rewrite, nh.Rewrite) ||
+			prev.HostHeader != nh.HostHeader || prev.Forwarded != nh.Forwarded:
+			logger.Info("reload: changed host",
+				zap.String("host", host),
+				zap.String("old destination", prev.DestinationAddr),
+				zap.String("destination", nh.DestinationAddr),
+				zap.Bool("always404", nh.Always404),
+			)
+		}
+	}
+	for host, prev := range oldHosts {
+		if _, ok := hosts[host]; !ok {
+			logger.Info("reload: removed host",
+				zap.String("host", host),
+				zap.String("old destination", prev.DestinationAddr),
+			)
+		}
+	}
+	for port, nh := range rt.defaultRoute {
+		prev, ok := old.defaultRoute[port]
+		if !ok || prev.DestinationAddr != nh.DestinationAddr {
+			logger.Info("reload: default route",
+				zap.String("source port", port),
+				zap.String("destination", nh.DestinationAddr),
+			)
+		}
+	}
+	for port := range old.defaultRoute {
+		if _, ok := rt.defaultRoute[port]; !ok {
+			logger.Info("reload: removed default route",
+				zap.String("source port", port),
+			)
+		}
+	}
+}
+
+var (
+	// ErrRouteNotFound is returned when there is no route with a given name
+	ErrRouteNotFound = errors.New("route not found")
+	// ErrRouteExists is returned when adding a route whose name is in use
+	ErrRouteExists = errors.New("route already exists")
+	// ErrRouteReference is returned when persisting a change would replace
+	// a ${} reference in a config file with its value
+	ErrRouteReference = errors.New("field has a ${} reference and must be changed in the config file")
+)
+
+// Routes returns the routes currently in effect. They must not be modified.
+func (n *NameRouter) Routes() []*Namehost {
+	n.RLock()
+	defer n.RUnlock()
+	return n.config.Routes
+}
+
+// Route returns the route with the given name
+func (n *NameRouter) Route(name string) (*Namehost, error) {
+	for _, nh := range n.Routes() {
+		if nh.Name == name {
+			return nh, nil
+		}
+	}
+	return nil, ErrRouteNotFound
+}
+
+// AddRoute adds a new route. The route is named after its first host if
+// it doesn't have a name.
+func (n *NameRouter) AddRoute(nh *Namehost) error {
+	nh = nh.copy()
+	nh.pos = position{}
+	if nh.Name == "" {
+		nh.Name = nh.defaultName()
+	}
+
+	return n.changeRoutes(func(routes []*Namehost) ([]*Namehost, error) {
+		for _, r := range routes {
+			if r.Name == nh.Name {
+				return nil, fmt.Errorf("%w: %s", ErrRouteExists, nh.Name)
+			}
+		}
+		return append(routes, nh), nil
+	})
+}
+
+// UpdateRoute calls fn with a copy of the named route, and replaces the
+// route with it if fn doesn't return an error
+func (n *NameRouter) UpdateRoute(name string, fn func(nh *Namehost) error) error {
+	return n.changeRoutes(func(routes []*Namehost) ([]*Namehost, error) {
+		for _, r := range routes {
+			if r.Name != name {
+				continue
+			}
+			// Decoding changes into the route sets its line, keep the one
+			// it has in its file so that it can be found there again
+			pos := r.pos
+			if err := fn(r); err != nil {
+				return nil, err
+			}
+			r.pos = pos
+			return routes, nil
+		}
+		return nil, fmt.Errorf("%w: %s", ErrRouteNotFound, name)
+	})
+}
+
+// RemoveRoute removes the named route
+func (n *NameRouter) RemoveRoute(name string) error {
+	return n.changeRoutes(func(routes []*Namehost) ([]*Namehost, error) {
+		for i, r := range routes {
+			if r.Name == name {
+				return append(routes[:i], routes[i+1:]...), nil
+			}
+		}
+		return nil, fmt.Errorf("%w: %s", ErrRouteNotFound, name)
+	})
+}
+
+// changeRoutes calls fn with copies of the current routes, and swaps in the
+// routes it returns. The change is persisted to the config files when the
+// admin API is configured to.
+func (n *NameRouter) changeRoutes(fn func(routes []*Namehost) ([]*Namehost, error)) error {
+	n.reloadLock.Lock()
+	defer n.reloadLock.Unlock()
+
+	n.RLock()
+	current := n.config
+	n.RUnlock()
+
+	routes := make([]*Namehost, 0, len(current.Routes))
+	for _, nh := range current.Routes {
+		routes = append(routes, nh.copy())
+	}
+
+	routes, err := fn(routes)
+	if err != nil {
+		return err
+	}
+
+	config := *current
+	config.Routes = routes
+
+	// The files are worked out before the routes go live, because that
+	// records the routes' positions in the new files
+	var files []routeFile
+	if config.Admin != nil && config.Admin.Persist {
+		files, err = persistRoutes(current, &config)
+		if err != nil {
+			return fmt.Errorf("route change can't be persisted: %w", err)
+		}
+	}
+
+	if err := n.reload(&config); err != nil {
+		return err
+	}
+
+	if err := writeRouteFiles(files); err != nil {
+		n.logger.Error("failed to persist route change",
+			zap.Error(err),
+		)
+		return fmt.Errorf("route change was applied but not persisted: %w", err)
+	}
+
+	return nil
+}
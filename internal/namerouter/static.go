@@ -0,0 +1,241 @@
+package namerouter
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+	"go.yaml.in/yaml/v3"
+)
+
+// Static makes a route serve files from a local directory instead of
+// proxying. It can be written as just the root directory.
+type Static struct {
+	// Root is the directory files are served from. A relative root is
+	// relative to the directory of the config file the route is in.
+	Root string `yaml:"root"`
+	// Index are the files served for a directory, in order of preference.
+	// It defaults to index.html.
+	Index []string `yaml:"index,omitempty"`
+	// ListDirectories serves a listing for directories without an index
+	ListDirectories bool `yaml:"listDirectories,omitempty"`
+	// SPA serves the root index file for any path that doesn't exist, for
+	// single page apps that route in the browser
+	SPA bool `yaml:"spa,omitempty"`
+}
+
+func (s *Static) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		s.Root = value.Value
+		return nil
+	}
+	type plain Static
+	return value.Decode((*plain)(s))
+}
+
+func (s *Static) indexFiles() []string {
+	if len(s.Index) == 0 {
+		return []string{"index.html"}
+	}
+	return s.Index
+}
+
+// staticRoot returns the directory a route serves files from
+func (nh *Namehost) staticRoot() string {
+	if filepath.IsAbs(nh.Static.Root) || nh.pos.file == "" {
+		return nh.Static.Root
+	}
+	root := filepath.Join(filepath.Dir(nh.pos.file), nh.Static.Root)
+	if abs, err := filepath.Abs(root); err == nil {
+		return abs
+	}
+	return root
+}
+
+// staticResult is what a static route responds with for a request
+type staticResult struct {
+	// file is the file to serve, relative to the root
+	file string
+	// dir is set instead of file to serve a directory listing
+	dir string
+	// redirect is set to redirect directories to their path with a slash
+	redirect string
+	// status is set when responding with an error
+	status int
+}
+
+// resolveStatic works out what a static route serves for a request path.
+// Files are only opened through an os.Root, so that neither .. nor
+// symlinks can reach outside the root.
+func (nh *Namehost) resolveStatic(root *os.Root, urlPath string) staticResult {
+	name := strings.TrimPrefix(path.Clean("/"+urlPath), "/")
+	if name == "" {
+		name = "."
+	}
+
+	info, err := root.Stat(name)
+	switch {
+	case errors.Is(err, fs.ErrPermission):
+		return staticResult{status: http.StatusForbidden}
+	case err != nil:
+		return nh.staticFallback(root)
+	case !info.IsDir():
+		return staticResult{file: name}
+	}
+
+	if !strings.HasSuffix(urlPath, "/") {
+		return staticResult{redirect: path.Base(urlPath) + "/"}
+	}
+
+	for _, index := range nh.Static.indexFiles() {
+		file := path.Join(name, index)
+		if info, err := root.Stat(file); err == nil && !info.IsDir() {
+			return staticResult{file: file}
+		}
+	}
+
+	if nh.Static.ListDirectories {
+		return staticResult{dir: name}
+	}
+	return nh.staticFallback(root)
+}
+
+// staticFallback is used when a path doesn't exist or is a directory that
+// can't be served. SPA routes serve their root index file.
+func (nh *Namehost) staticFallback(root *os.Root) staticResult {
+	if nh.Static.SPA {
+		for _, index := range nh.Static.indexFiles() {
+			if info, err := root.Stat(index); err == nil && !info.IsDir() {
+				return staticResult{file: index}
+			}
+		}
+	}
+	return staticResult{status: http.StatusNotFound}
+}
+
+// serveStatic serves a request for a static route. http.ServeContent sets
+// the content type and Last-Modified, and handles Range and conditional
+// requests, including for the ETag set here.
+func (n *NameRouter) serveStatic(nh *Namehost, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	root, err := os.OpenRoot(nh.staticRoot())
+	if err != nil {
+		n.logger.Error("failed to open static root",
+			zap.String("root", nh.staticRoot()),
+			zap.Error(err),
+		)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	defer root.Close()
+
+	res := nh.resolveStatic(root, r.URL.Path)
+	switch {
+	case res.status != 0:
+		http.Error(w, http.StatusText(res.status), res.status)
+		return
+	case res.redirect != "":
+		if r.URL.RawQuery != "" {
+			res.redirect += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, res.redirect, http.StatusMovedPermanently)
+		return
+	case res.dir != "":
+		serveListing(w, root, res.dir)
+		return
+	}
+
+	f, err := root.Open(res.file)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	n.logger.Info("serve static file",
+		zap.String("Host", r.Host),
+		zap.String("source", r.RemoteAddr),
+		zap.Stringer("client IP", n.client(r).IP),
+		zap.String("client", n.clientClass(r)),
+		zap.String("file", res.file),
+		zap.String("Request", r.RequestURI),
+	)
+
+	w.Header().Set("ETag", fmt.Sprintf(`W/"%x-%x"`, info.ModTime().UnixNano(), info.Size()))
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
+
+// serveListing writes an HTML listing of a directory
+func serveListing(w http.ResponseWriter, root *os.Root, dir string) {
+	f, err := root.Open(dir)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+
+	entries, err := f.ReadDir(-1)
+	if err != nil {
+		http.Error(w, "error reading directory", http.StatusInternalServerError)
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, "<!doctype html>\n<pre>\n")
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() {
+			name += "/"
+		}
+		u := url.URL{Path: name}
+		fmt.Fprintf(w, "<a href=\"%s\">%s</a>\n", html.EscapeString(u.String()), html.EscapeString(name))
+	}
+	fmt.Fprintf(w, "</pre>\n")
+}
+
+// validateStatic checks a static route's config and that its root is a
+// directory
+func (nh *Namehost) validateStatic() ValidationErrors {
+	errs := ValidationErrors{}
+
+	if nh.Static.Root == "" {
+		errs = append(errs, nh.pos.errorf("static route is missing a root"))
+		return errs
+	}
+	for _, index := range nh.Static.Index {
+		if index == "" || strings.Contains(index, "/") {
+			errs = append(errs, nh.pos.errorf("static index %q must be a file name", index))
+		}
+	}
+
+	info, err := os.Stat(nh.staticRoot())
+	switch {
+	case err != nil:
+		errs = append(errs, nh.pos.errorf("invalid static root: %s", err.Error()))
+	case !info.IsDir():
+		errs = append(errs, nh.pos.errorf("static root %s is not a directory", nh.staticRoot()))
+	}
+
+	return errs
+}
@@ -0,0 +1,97 @@
+package namerouter
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveStatic(t *testing.T) {
+	dir := t.TempDir()
+	outside := filepath.Join(dir, "secret")
+	if err := os.WriteFile(outside, []byte("secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	site := filepath.Join(dir, "site")
+	for _, name := range []string{"docs", "empty"} {
+		if err := os.MkdirAll(filepath.Join(site, name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"index.html", "app.js", "docs/index.html"} {
+		if err := os.WriteFile(filepath.Join(site, name), []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(outside, filepath.Join(site, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	root, err := os.OpenRoot(site)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+
+	tests := []struct {
+		name   string
+		static Static
+		path   string
+		want   staticResult
+	}{
+		{name: "root index", path: "/", want: staticResult{file: "index.html"}},
+		{name: "file", path: "/app.js", want: staticResult{file: "app.js"}},
+		{name: "directory index", path: "/docs/", want: staticResult{file: "docs/index.html"}},
+		{name: "directory without slash", path: "/docs", want: staticResult{redirect: "docs/"}},
+		{name: "missing", path: "/nope", want: staticResult{status: http.StatusNotFound}},
+		{name: "dot dot", path: "/../secret", want: staticResult{status: http.StatusNotFound}},
+		{name: "nested dot dot", path: "/docs/../../secret", want: staticResult{status: http.StatusNotFound}},
+		{name: "symlink outside the root", path: "/link", want: staticResult{status: http.StatusNotFound}},
+		{name: "no index", path: "/empty/", want: staticResult{status: http.StatusNotFound}},
+		{name: "listing", static: Static{ListDirectories: true}, path: "/empty/", want: staticResult{dir: "empty"}},
+		{name: "spa", static: Static{SPA: true}, path: "/some/route", want: staticResult{file: "index.html"}},
+		{name: "spa doesn't escape", static: Static{SPA: true}, path: "/../secret", want: staticResult{file: "index.html"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			static := tt.static
+			nh := &Namehost{Static: &static}
+			if got := nh.resolveStatic(root, tt.path); got != tt.want {
+				t.Errorf("resolveStatic(%q) = %+v, want %+v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStaticRoute(t *testing.T) {
+	dir := t.TempDir()
+	writeConfig(t, dir, "site/index.html", "home")
+	writeConfig(t, dir, "site/app.js", "js")
+	_, s := testServer(t, testConfigFile(t, filepath.Join(dir, "config.yaml"), routerConfig(`    static: site
+`)))
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+		body   string
+	}{
+		{method: http.MethodGet, path: "/", status: http.StatusOK, body: "home"},
+		{method: http.MethodGet, path: "/app.js", status: http.StatusOK, body: "js"},
+		{method: http.MethodGet, path: "/nope", status: http.StatusNotFound},
+		{method: http.MethodPost, path: "/app.js", status: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+tt.path, func(t *testing.T) {
+			status, body := do(t, s, tt.method, "app.test", tt.path, "")
+			if status != tt.status {
+				t.Fatalf("status = %d, want %d", status, tt.status)
+			}
+			if tt.body != "" && body != tt.body {
+				t.Errorf("body = %q, want %q", body, tt.body)
+			}
+		})
+	}
+}
@@ -0,0 +1,605 @@
+package namerouter
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+	"go.yaml.in/yaml/v3"
+)
+
+// ValidationError is a single problem found in a config, with the file and
+// line it was found at when known
+type ValidationError struct {
+	File string
+	Line int
+	Msg  string
+}
+
+func (e *ValidationError) Error() string {
+	switch {
+	case e.File != "" && e.Line > 0:
+		return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+	case e.File != "":
+		return fmt.Sprintf("%s: %s", e.File, e.Msg)
+	case e.Line > 0:
+		return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+	}
+	return e.Msg
+}
+
+// ValidationErrors is the list of problems found in a config
+type ValidationErrors []*ValidationError
+
+func (v ValidationErrors) Error() string {
+	msgs := make([]string, 0, len(v))
+	for _, e := range v {
+		msgs = append(msgs, e.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+func (p position) errorf(format string, args ...interface{}) *ValidationError {
+	return &ValidationError{
+		File: p.file,
+		Line: p.line,
+		Msg:  fmt.Sprintf(format, args...),
+	}
+}
+
+var yamlLineErr = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+
+// yamlErrors converts errors returned by the YAML parser into
+// ValidationErrors, pulling out the line numbers they contain
+func yamlErrors(file string, err error) ValidationErrors {
+	var msgs []string
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		msgs = typeErr.Errors
+	} else {
+		msgs = []string{err.Error()}
+	}
+
+	errs := ValidationErrors{}
+	for _, msg := range msgs {
+		e := &ValidationError{File: file, Msg: msg}
+		if m := yamlLineErr.FindStringSubmatch(msg); m != nil {
+			e.Line, _ = strconv.Atoi(m[1])
+			e.Msg = m[2]
+		}
+		errs = append(errs, e)
+	}
+
+	return errs
+}
+
+// ValidateFile strictly parses and validates the config file at the given
+// path and the files it includes. Unknown keys and warnings are reported in
+// addition to everything checked by Config.Validate. A nil error means the config is valid, otherwise the
+// error is a ValidationErrors.
+func ValidateFile(file string) error {
+	config, errs := loadConfig(file, true)
+	if config == nil {
+		return errs
+	}
+
+	config.SetDefaults()
+	if err := config.Validate(); err != nil {
+		var vErr ValidationErrors
+		if errors.As(err, &vErr) {
+			errs = append(errs, vErr...)
+		} else {
+			errs = append(errs, &ValidationError{File: file, Msg: err.Error()})
+		}
+	}
+	errs = append(errs, config.Warnings()...)
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// unknownFields walks a YAML node alongside the Go type it is decoded into
+// and reports any mapping keys that don't correspond to a field
+func unknownFields(file string, node *yaml.Node, t reflect.Type) ValidationErrors {
+	errs := ValidationErrors{}
+	if node == nil {
+		return errs
+	}
+
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch node.Kind {
+	case yaml.MappingNode:
+		switch t.Kind() {
+		case reflect.Struct:
+			fields := yamlFields(t)
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				key, value := node.Content[i], node.Content[i+1]
+				ft, ok := fields[key.Value]
+				if !ok {
+					errs = append(errs, &ValidationError{
+						File: file,
+						Line: key.Line,
+						Msg:  fmt.Sprintf("unknown field %q", key.Value),
+					})
+					continue
+				}
+				errs = append(errs, unknownFields(file, value, ft)...)
+			}
+		case reflect.Map:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				errs = append(errs, unknownFields(file, node.Content[i+1], t.Elem())...)
+			}
+		}
+	case yaml.SequenceNode:
+		if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+			for _, item := range node.Content {
+				errs = append(errs, unknownFields(file, item, t.Elem())...)
+			}
+		}
+	}
+
+	return errs
+}
+
+// yamlFields maps the YAML key names of a struct's fields to their types
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		tag := f.Tag.Get("yaml")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if strings.Contains(opts, "inline") {
+			for k, v := range yamlFields(f.Type) {
+				fields[k] = v
+			}
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		fields[name] = f.Type
+	}
+	return fields
+}
+
+// Validate checks the config for problems that would stop it from working
+// as intended. A nil error means the config is valid, otherwise the error
+// is a ValidationErrors.
+func (c *Config) Validate() error {
+	errs := ValidationErrors{}
+
+	if c.RateLimits != nil {
+		errs = append(errs, c.RateLimits.Internal.validate("internal")...)
+		errs = append(errs, c.RateLimits.External.validate("external")...)
+		if p := c.RateLimits.IPv4Prefix; p < 0 || p > 8*net.IPv4len {
+			errs = append(errs, c.keyPos("rateLimits").errorf("ipv4Prefix %d must be between 1 and 32", p))
+		}
+		if p := c.RateLimits.IPv6Prefix; p < 0 || p > 8*net.IPv6len {
+			errs = append(errs, c.keyPos("rateLimits").errorf("ipv6Prefix %d must be between 1 and 128", p))
+		}
+	}
+
+	if c.HTTPPort < 0 || c.HTTPPort > 65535 {
+		errs = append(errs, c.keyPos("httpPort").errorf("httpPort %d is not a valid port", c.HTTPPort))
+	}
+	if c.HTTPSPort < 0 || c.HTTPSPort > 65535 {
+		errs = append(errs, c.keyPos("httpsPort").errorf("httpsPort %d is not a valid port", c.HTTPSPort))
+	}
+
+	if c.Admin != nil && c.Admin.Addr != "" && c.Admin.Token == "" {
+		errs = append(errs, c.keyPos("admin").errorf("admin token is required when the admin API is enabled"))
+	}
+
+	for _, ip := range c.ListenAddresses {
+		if net.ParseIP(strings.Trim(ip, "[]")) == nil {
+			errs = append(errs, c.keyPos("listenAddresses").errorf("listen address %q is not an IP address", ip))
+		}
+	}
+
+	for _, network := range c.InternalNetworks {
+		if _, err := parseNetworks([]string{network}); err != nil {
+			errs = append(errs, c.keyPos("internalNetworks").errorf("%s", err.Error()))
+		}
+	}
+
+	for _, network := range c.TrustedProxies {
+		if _, err := parseNetworks([]string{network}); err != nil {
+			errs = append(errs, c.keyPos("trustedProxies").errorf("%s", err.Error()))
+		}
+	}
+
+	switch c.trustedProxyHeader() {
+	case TrustedProxyHeaderXForwardedFor, TrustedProxyHeaderXRealIP, TrustedProxyHeaderForwarded:
+	default:
+		errs = append(errs, c.keyPos("trustedProxyHeader").errorf("unknown trustedProxyHeader %q, must be %s, %s or %s",
+			c.TrustedProxyHeader, TrustedProxyHeaderXForwardedFor, TrustedProxyHeaderXRealIP, TrustedProxyHeaderForwarded))
+	}
+
+	switch c.InternalHostResponse {
+	case "", InternalHostNotFound, InternalHostForbidden, InternalHostClose:
+	default:
+		errs = append(errs, c.keyPos("internalHostResponse").errorf("unknown internalHostResponse %q, must be %s, %s or %s",
+			c.InternalHostResponse, InternalHostNotFound, InternalHostForbidden, InternalHostClose))
+	}
+
+	seenMiddleware := make(map[string]bool)
+	for _, name := range c.Middlewares {
+		if seenMiddleware[name] {
+			errs = append(errs, c.keyPos("middlewares").errorf("middleware %q is listed more than once", name))
+			continue
+		}
+		seenMiddleware[name] = true
+		if _, ok := registeredMiddleware(name); !ok && !builtinMiddlewares[name] {
+			errs = append(errs, c.keyPos("middlewares").errorf("unknown middleware %q", name))
+		}
+	}
+
+	hosts := make(map[string]*Namehost)
+	defaults := make(map[string]*Namehost)
+	names := make(map[string]*Namehost)
+
+	empty := 0
+	for _, nh := range c.Routes {
+		if nh == nil {
+			errs = append(errs, c.emptyRoutePos(empty).errorf("empty route"))
+			empty++
+			continue
+		}
+		errs = append(errs, nh.validate()...)
+
+		if nh.Name != "" {
+			if other, ok := names[nh.Name]; ok {
+				errs = append(errs, nh.pos.errorf("route name %q is already used at %s", nh.Name, other.pos))
+			} else {
+				names[nh.Name] = nh
+			}
+		}
+
+		seen := make(map[string]bool)
+		for _, host := range append(append([]string{}, nh.ExternalHosts...), nh.InternalHosts...) {
+			// Hosts are compared the way requests are matched, so that
+			// APP.local and app.local are duplicates. Repeats within a
+			// route are only a warning.
+			key := normalizeConfigHost(host)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			if host == "default" {
+				ports := []string{"80", "443"}
+				if nh.SourcePort != nil {
+					ports = []string{*nh.SourcePort}
+				}
+				for _, port := range ports {
+					if other, ok := defaults[port]; ok {
+						errs = append(errs, nh.pos.errorf("default route for port %s conflicts with default route at %s", port, other.pos))
+						continue
+					}
+					defaults[port] = nh
+				}
+				continue
+			}
+
+			if other, ok := hosts[key]; ok {
+				errs = append(errs, nh.pos.errorf("host %q is already defined at %s", host, other.pos))
+				continue
+			}
+			hosts[key] = nh
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// Warnings returns problems that don't stop the config from working, such as
+// settings that are ignored. Configs that only have warnings are loaded, and
+// the warnings are logged. namerouter validate reports them along with
+// errors.
+func (c *Config) Warnings() ValidationErrors {
+	warnings := ValidationErrors{}
+
+	if c.DoSSL && c.Email == "" {
+		warnings = append(warnings, c.keyPos("doSSL").errorf("doSSL is enabled without an email, Let's Encrypt can't send certificate expiry notices"))
+	}
+
+	for _, nh := range c.Routes {
+		if nh != nil {
+			warnings = append(warnings, nh.warnings()...)
+		}
+	}
+
+	return warnings
+}
+
+func (nh *Namehost) warnings() ValidationErrors {
+	warnings := ValidationErrors{}
+
+	if len(nh.ExternalHosts) == 0 && len(nh.InternalHosts) == 0 {
+		warnings = append(warnings, nh.pos.errorf("route has no internal or external hosts"))
+	}
+
+	seen := make(map[string]bool)
+	for _, host := range append(append([]string{}, nh.ExternalHosts...), nh.InternalHosts...) {
+		key := normalizeConfigHost(host)
+		if seen[key] {
+			warnings = append(warnings, nh.pos.errorf("host %q is listed more than once in the same route", host))
+		}
+		seen[key] = true
+	}
+
+	if nh.Always404 {
+		if nh.DestinationAddr != "" {
+			warnings = append(warnings, nh.pos.errorf("always404 route should not have a destination"))
+		}
+		if len(nh.Paths) > 0 {
+			warnings = append(warnings, nh.pos.errorf("always404 route should not have paths"))
+		}
+		if len(nh.Destinations) > 0 {
+			warnings = append(warnings, nh.pos.errorf("always404 route should not have destinations"))
+		}
+		if len(nh.Mirror) > 0 {
+			warnings = append(warnings, nh.pos.errorf("always404 route should not have mirrors"))
+		}
+		if len(nh.Rewrite) > 0 {
+			warnings = append(warnings, nh.pos.errorf("always404 route should not have rewrites"))
+		}
+		if nh.HostHeader != "" || nh.Forwarded != "" {
+			warnings = append(warnings, nh.pos.errorf("always404 route should not set hostHeader or forwarded"))
+		}
+		if len(nh.Match) > 0 {
+			warnings = append(warnings, nh.pos.errorf("always404 route should not have match rules"))
+		}
+		if nh.Redirect != nil {
+			warnings = append(warnings, nh.pos.errorf("always404 route should not have a redirect"))
+		}
+		if nh.Static != nil {
+			warnings = append(warnings, nh.pos.errorf("always404 route should not serve static files"))
+		}
+		return warnings
+	}
+
+	if nh.Redirect == nil && nh.Static == nil && nh.DestinationAddr == "" &&
+		len(nh.Paths) == 0 && len(nh.Match) == 0 && len(nh.Destinations) == 0 {
+		warnings = append(warnings, nh.pos.errorf("route is missing a destination"))
+	}
+
+	return warnings
+}
+
+// logWarnings logs the config's warnings
+func (c *Config) logWarnings(logger *zap.Logger) {
+	for _, w := range c.Warnings() {
+		logger.Warn("config warning",
+			zap.String("detail", w.Error()),
+		)
+	}
+}
+
+// emptyRoutePos returns the position of the i'th empty route, or of the
+// routes key if the config wasn't loaded from a file
+func (c *Config) emptyRoutePos(i int) position {
+	if i < len(c.emptyRoutes) {
+		return c.emptyRoutes[i]
+	}
+	return c.keyPos("routes")
+}
+
+func (r *RateLimitConfig) validate(name string) ValidationErrors {
+	errs := ValidationErrors{}
+	if r == nil {
+		return errs
+	}
+	if r.Rate <= 0 {
+		errs = append(errs, r.pos.errorf("%s rate limit must be greater than 0", name))
+	}
+	if r.Burst <= 0 {
+		errs = append(errs, r.pos.errorf("%s burst must be greater than 0", name))
+	}
+	return errs
+}
+
+func (nh *Namehost) validate() ValidationErrors {
+	errs := ValidationErrors{}
+
+	for _, host := range nh.ExternalHosts {
+		if host == "" {
+			errs = append(errs, nh.pos.errorf("empty external host"))
+		}
+	}
+	for _, host := range nh.InternalHosts {
+		if host == "" {
+			errs = append(errs, nh.pos.errorf("empty internal host"))
+		}
+	}
+	for _, host := range append(append([]string{}, nh.ExternalHosts...), nh.InternalHosts...) {
+		if err := validateHost(host); err != nil {
+			errs = append(errs, nh.pos.errorf("%s", err.Error()))
+		}
+	}
+
+	if nh.SourcePort != nil {
+		if p, err := strconv.Atoi(*nh.SourcePort); err != nil || p <= 0 || p > 65535 {
+			errs = append(errs, nh.pos.errorf("sourcePort %q is not a valid port", *nh.SourcePort))
+		}
+	}
+
+	seenMiddleware := make(map[string]bool)
+	for _, name := range nh.Middlewares {
+		if seenMiddleware[name] {
+			errs = append(errs, nh.pos.errorf("middleware %q is listed more than once", name))
+			continue
+		}
+		seenMiddleware[name] = true
+		if builtinMiddlewares[name] {
+			errs = append(errs, nh.pos.errorf("built in middleware %q can only be used in the global middlewares", name))
+			continue
+		}
+		if _, ok := registeredMiddleware(name); !ok {
+			errs = append(errs, nh.pos.errorf("unknown middleware %q", name))
+		}
+	}
+
+	errs = append(errs, nh.validateHeaders()...)
+	errs = append(errs, nh.validateForwarding()...)
+
+	// Everything else an always404 route sets is ignored, which is only a
+	// warning
+	if nh.Always404 {
+		return errs
+	}
+
+	if nh.Static != nil {
+		if nh.DestinationAddr != "" {
+			errs = append(errs, nh.pos.errorf("static route should not have a destination"))
+		}
+		if len(nh.Paths) > 0 {
+			errs = append(errs, nh.pos.errorf("static route should not have paths"))
+		}
+		if len(nh.Destinations) > 0 {
+			errs = append(errs, nh.pos.errorf("static route should not have destinations"))
+		}
+		if len(nh.Mirror) > 0 {
+			errs = append(errs, nh.pos.errorf("static route should not have mirrors"))
+		}
+		if len(nh.Rewrite) > 0 {
+			errs = append(errs, nh.pos.errorf("static route should not have rewrites"))
+		}
+		if nh.HostHeader != "" || nh.Forwarded != "" {
+			errs = append(errs, nh.pos.errorf("static route should not set hostHeader or forwarded"))
+		}
+		if len(nh.Match) > 0 {
+			errs = append(errs, nh.pos.errorf("static route should not have match rules"))
+		}
+		if nh.Redirect != nil {
+			errs = append(errs, nh.pos.errorf("static route should not have a redirect"))
+		}
+		errs = append(errs, nh.validateStatic()...)
+		return errs
+	}
+
+	if nh.Redirect != nil {
+		if nh.DestinationAddr != "" {
+			errs = append(errs, nh.pos.errorf("redirect route should not have a destination"))
+		}
+		if len(nh.Paths) > 0 {
+			errs = append(errs, nh.pos.errorf("redirect route should not have paths"))
+		}
+		if len(nh.Destinations) > 0 {
+			errs = append(errs, nh.pos.errorf("redirect route should not have destinations"))
+		}
+		if len(nh.Mirror) > 0 {
+			errs = append(errs, nh.pos.errorf("redirect route should not have mirrors"))
+		}
+		if len(nh.Rewrite) > 0 {
+			errs = append(errs, nh.pos.errorf("redirect route should not have rewrites"))
+		}
+		if nh.HostHeader != "" || nh.Forwarded != "" {
+			errs = append(errs, nh.pos.errorf("redirect route should not set hostHeader or forwarded"))
+		}
+		if len(nh.Match) > 0 {
+			errs = append(errs, nh.pos.errorf("redirect route should not have match rules"))
+		}
+		errs = append(errs, nh.Redirect.validate(nh)...)
+		return errs
+	}
+
+	errs = append(errs, nh.validatePaths()...)
+	errs = append(errs, nh.validateDestinations()...)
+	errs = append(errs, nh.validateMirrors()...)
+	errs = append(errs, nh.validateRewrites()...)
+
+	for i, rule := range nh.Match {
+		if rule == nil {
+			errs = append(errs, nh.pos.errorf("empty match rule"))
+			continue
+		}
+		errs = append(errs, rule.validate(nh, i)...)
+	}
+
+	if nh.DestinationAddr == "" {
+		return errs
+	}
+
+	if err := validateDestination(nh.DestinationAddr); err != nil {
+		errs = append(errs, nh.pos.errorf("%s", err.Error()))
+	}
+
+	return errs
+}
+
+func (nh *Namehost) validatePaths() ValidationErrors {
+	errs := ValidationErrors{}
+
+	type pathKey struct {
+		path  string
+		exact bool
+	}
+	seen := make(map[pathKey]bool)
+
+	for _, p := range nh.Paths {
+		if p == nil {
+			errs = append(errs, nh.pos.errorf("empty path"))
+			continue
+		}
+		if !strings.HasPrefix(p.Path, "/") {
+			errs = append(errs, nh.pos.errorf("path %q must start with /", p.Path))
+			continue
+		}
+		key := pathKey{path: p.Path, exact: p.Exact}
+		if seen[key] {
+			errs = append(errs, nh.pos.errorf("path %q is listed more than once", p.Path))
+		}
+		seen[key] = true
+
+		if p.DestinationAddr == "" {
+			errs = append(errs, nh.pos.errorf("path %q is missing a destination", p.Path))
+			continue
+		}
+		if err := validateDestination(p.DestinationAddr); err != nil {
+			errs = append(errs, nh.pos.errorf("path %q: %s", p.Path, err.Error()))
+		}
+	}
+
+	return errs
+}
+
+// validateDestination checks that a destination is a usable upstream URL
+func validateDestination(dest string) error {
+	u, err := url.Parse(dest)
+	if err != nil {
+		return fmt.Errorf("invalid destination %q: %w", dest, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("destination %q must be an http or https URL", dest)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("destination %q is missing a host", dest)
+	}
+	if strings.Count(u.Host, ":") > 1 && !strings.HasPrefix(u.Host, "[") {
+		return fmt.Errorf("destination %q has an IPv6 address that isn't in brackets, like http://[::1]:8080", dest)
+	}
+	return nil
+}
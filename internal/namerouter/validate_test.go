@@ -0,0 +1,214 @@
+package namerouter
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// writeConfig writes a config file into dir and returns its path
+func writeConfig(t *testing.T, dir string, name string, config string) string {
+	t.Helper()
+	file := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// validationErrors returns the errors from validating a config file as
+// file:line: message strings, with the file relative to dir
+func validationErrors(t *testing.T, dir string, file string) []string {
+	t.Helper()
+	err := ValidateFile(file)
+	if err == nil {
+		return nil
+	}
+	var vErrs ValidationErrors
+	if !errors.As(err, &vErrs) {
+		t.Fatalf("ValidateFile() = %v, want ValidationErrors", err)
+	}
+	var msgs []string
+	for _, e := range vErrs {
+		msgs = append(msgs, strings.ReplaceAll(e.Error(), dir+string(filepath.Separator), ""))
+	}
+	return msgs
+}
+
+func TestValidateFile(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   []string
+	}{
+		{
+			name: "valid",
+			config: `routes:
+  - internal: [app.local]
+    destination: http://127.0.0.1:8080
+`,
+		},
+		{
+			name: "unknown field",
+			config: `routes:
+  - internal: [app.local]
+    destinaton: http://127.0.0.1:8080
+`,
+			want: []string{
+				"c.yaml:3: unknown field \"destinaton\"",
+				"c.yaml:2: route is missing a destination",
+			},
+		},
+		{
+			name: "wrong type",
+			config: `httpPort: eighty
+routes: []
+`,
+			want: []string{"c.yaml:1: cannot unmarshal !!str `eighty` into int"},
+		},
+		{
+			name: "syntax error",
+			config: `routes:
+  - internal: [app.local]
+	destination: http://127.0.0.1:8080
+`,
+			want: []string{"c.yaml:3: found character that cannot start any token"},
+		},
+		{
+			name: "empty route",
+			config: `routes:
+  - internal: [app.local]
+    destination: http://127.0.0.1:8080
+  -
+`,
+			want: []string{"c.yaml:4: empty route"},
+		},
+		{
+			name: "destination",
+			config: `routes:
+  - internal: [app.local]
+    destination: localhost:8080
+`,
+			want: []string{"c.yaml:2: destination \"localhost:8080\" must be an http or https URL"},
+		},
+		{
+			name: "ipv6 destination",
+			config: `routes:
+  - internal: [app.local]
+    destination: http://::1:8080
+  - internal: [app2.local]
+    destination: http://[::1]:8080
+`,
+			want: []string{"c.yaml:2: destination \"http://::1:8080\" has an IPv6 address that isn't in brackets, like http://[::1]:8080"},
+		},
+		{
+			name: "duplicate host",
+			config: `routes:
+  - internal: [app.local]
+    destination: http://127.0.0.1:8080
+  - external: [APP.local]
+    destination: http://127.0.0.1:8081
+`,
+			want: []string{"c.yaml:4: host \"APP.local\" is already defined at c.yaml:2"},
+		},
+		{
+			name: "conflicting defaults",
+			config: `routes:
+  - internal: [default]
+    destination: http://127.0.0.1:8080
+  - internal: [default]
+    sourcePort: "443"
+    destination: http://127.0.0.1:8081
+`,
+			want: []string{"c.yaml:4: default route for port 443 conflicts with default route at c.yaml:2"},
+		},
+		{
+			name: "rate limits",
+			config: `rateLimits:
+  external:
+    rate: 0
+    burst: 5
+routes: []
+`,
+			want: []string{"c.yaml:3: external rate limit must be greater than 0"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			got := validationErrors(t, dir, writeConfig(t, dir, "c.yaml", tt.config))
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("ValidateFile() =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEmptyRouteIsRejected(t *testing.T) {
+	dir := t.TempDir()
+	file := writeConfig(t, dir, "c.yaml", `routes:
+  - internal: [app.local]
+    destination: http://127.0.0.1:8080
+  -
+`)
+	config, err := LoadConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newNameRouter(config, zap.NewNop(), &options{}); err == nil {
+		t.Fatal("config with an empty route was loaded")
+	}
+
+	n := &NameRouter{logger: zap.NewNop()}
+	if _, err := n.buildRouteTable(&Config{Routes: []*Namehost{nil}}); err == nil {
+		t.Fatal("route table was built with an empty route")
+	}
+}
+
+func TestWarnings(t *testing.T) {
+	dir := t.TempDir()
+	file := writeConfig(t, dir, "c.yaml", `doSSL: true
+routes:
+  - always404: true
+    external: [old.example.com]
+    destination: http://127.0.0.1:8080
+  - internal: [app.local, APP.local]
+    destination: http://127.0.0.1:8081
+`)
+	want := []string{
+		"c.yaml:1: doSSL is enabled without an email, Let's Encrypt can't send certificate expiry notices",
+		"c.yaml:3: always404 route should not have a destination",
+		"c.yaml:6: host \"APP.local\" is listed more than once in the same route",
+	}
+
+	// Warnings don't stop the config from being loaded
+	config, err := LoadConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newNameRouter(config, zap.NewNop(), &options{}); err != nil {
+		t.Fatalf("config with warnings wasn't loaded: %v", err)
+	}
+
+	var got []string
+	for _, w := range config.Warnings() {
+		got = append(got, strings.ReplaceAll(w.Error(), dir+string(filepath.Separator), ""))
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("Warnings() =\n%q\nwant\n%q", got, want)
+	}
+
+	// but are reported by validate
+	if got := validationErrors(t, dir, file); !slices.Equal(got, want) {
+		t.Errorf("ValidateFile() =\n%q\nwant\n%q", got, want)
+	}
+}
@@ -0,0 +1,210 @@
+// Package namerouter is a name based virtual host router that can be
+// embedded in other Go programs.
+//
+// A router is created from a Config and Options, and can be used as an
+// http.Handler, served on any net.Listener, or started on its configured
+// ports. Routes can be added and removed while it is running.
+//
+//	router, err := namerouter.New(&namerouter.Config{
+//		Routes: []*namerouter.Namehost{
+//			{
+//				DestinationAddr: "http://10.0.0.1:8080",
+//				InternalHosts:   []string{"app1.local"},
+//			},
+//		},
+//	}, namerouter.WithLogger(logger))
+//	if err != nil {
+//		return err
+//	}
+//	return router.Serve(listener)
+package namerouter
+
+import (
+	"context"
+	"net"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/acme/autocert"
+
+	"github.com/robbydyer/namerouter/internal/namerouter"
+)
+
+type (
+	// NameRouter is a running router
+	NameRouter = namerouter.NameRouter
+	// Client describes the client that sent a request
+	Client = namerouter.Client
+	// Config is the router configuration, the same as the config file
+	Config = namerouter.Config
+	// Namehost is a route
+	Namehost = namerouter.Namehost
+	// Path sends part of a route to a different destination
+	Path = namerouter.Path
+	// Redirect makes a route redirect clients instead of proxying
+	Redirect = namerouter.Redirect
+	// Static makes a route serve files from a directory
+	Static = namerouter.Static
+	// WeightedDestination is one of several destinations a route splits its
+	// traffic between
+	WeightedDestination = namerouter.WeightedDestination
+	// Mirror is a destination that gets a copy of a route's requests
+	Mirror = namerouter.Mirror
+	// Rewrite changes the path and query of a route's requests
+	Rewrite = namerouter.Rewrite
+	// HeaderRule changes the headers of a route's requests and responses
+	HeaderRule = namerouter.HeaderRule
+	// HeaderOps are the changes a HeaderRule makes to a set of headers
+	HeaderOps = namerouter.HeaderOps
+	// MatchRule sends requests to a different destination based on their
+	// method, headers, query or cookies
+	MatchRule = namerouter.MatchRule
+	// RateLimits are the rate limits for internal and external clients
+	RateLimits = namerouter.RateLimits
+	// RateLimitConfig is a single rate limit
+	RateLimitConfig = namerouter.RateLimitConfig
+	// Admin configures the admin API
+	Admin = namerouter.Admin
+	// Option configures a NameRouter
+	Option = namerouter.Option
+	// ValidationError is a single problem found in a Config
+	ValidationError = namerouter.ValidationError
+	// ValidationErrors is the list of problems found in a Config
+	ValidationErrors = namerouter.ValidationErrors
+	// EffectiveConfig is the resolved configuration a router runs with
+	EffectiveConfig = namerouter.EffectiveConfig
+	// ExplainRequest describes a simulated request for Explain
+	ExplainRequest = namerouter.ExplainRequest
+	// Explanation describes how a request would be routed
+	Explanation = namerouter.Explanation
+)
+
+var (
+	// ErrRouteNotFound is returned when there is no route with a given name
+	ErrRouteNotFound = namerouter.ErrRouteNotFound
+	// ErrRouteExists is returned when adding a route whose name is in use
+	ErrRouteExists = namerouter.ErrRouteExists
+	// ErrRouteReference is returned when persisting a change would replace
+	// a ${} reference in a config file with its value
+	ErrRouteReference = namerouter.ErrRouteReference
+)
+
+// Names of the built in middleware, for use in Config.Middlewares
+const (
+	MiddlewareRateLimit     = namerouter.MiddlewareRateLimit
+	MiddlewareNamehost      = namerouter.MiddlewareNamehost
+	MiddlewareSourcePort    = namerouter.MiddlewareSourcePort
+	MiddlewareHostHeader    = namerouter.MiddlewareHostHeader
+	MiddlewareHTTPSRedirect = namerouter.MiddlewareHTTPSRedirect
+)
+
+// Redirect presets
+const (
+	RedirectWWWToApex = namerouter.RedirectWWWToApex
+	RedirectApexToWWW = namerouter.RedirectApexToWWW
+)
+
+// Responses to external clients requesting an internal host, for use in
+// Config.InternalHostResponse
+const (
+	InternalHostNotFound  = namerouter.InternalHostNotFound
+	InternalHostForbidden = namerouter.InternalHostForbidden
+	InternalHostClose     = namerouter.InternalHostClose
+)
+
+// Host header modes, for use in Namehost.HostHeader
+const (
+	HostHeaderPassthrough = namerouter.HostHeaderPassthrough
+	HostHeaderDestination = namerouter.HostHeaderDestination
+)
+
+// Forwarded header modes, for use in Namehost.Forwarded
+const (
+	ForwardedXForwarded = namerouter.ForwardedXForwarded
+	ForwardedRFC7239    = namerouter.ForwardedRFC7239
+	ForwardedBoth       = namerouter.ForwardedBoth
+)
+
+// Headers trusted proxies report the client IP in, for use in
+// Config.TrustedProxyHeader
+const (
+	TrustedProxyHeaderXForwardedFor = namerouter.TrustedProxyHeaderXForwardedFor
+	TrustedProxyHeaderXRealIP       = namerouter.TrustedProxyHeaderXRealIP
+	TrustedProxyHeaderForwarded     = namerouter.TrustedProxyHeaderForwarded
+)
+
+// New creates a NameRouter for the given config. Nothing is listened on
+// until Start or Serve is called.
+func New(config *Config, opts ...Option) (*NameRouter, error) {
+	return namerouter.New(config, opts...)
+}
+
+// LoadConfig reads and parses a YAML, JSON or TOML config file, along with
+// any route files it includes
+func LoadConfig(file string) (*Config, error) {
+	return namerouter.LoadConfig(file)
+}
+
+// ValidateFile strictly parses and validates a config file. A non-nil
+// error is a ValidationErrors.
+func ValidateFile(file string) error {
+	return namerouter.ValidateFile(file)
+}
+
+// Explain describes how a router using config would route a request
+func Explain(config *Config, req *ExplainRequest) (*Explanation, error) {
+	return namerouter.Explain(config, req)
+}
+
+// Effective returns the configuration a router using config and opts would
+// run with
+func Effective(config *Config, opts ...Option) (*EffectiveConfig, error) {
+	return namerouter.Effective(config, opts...)
+}
+
+// WithLogger sets the logger. Without it, a logger is created based on
+// Config.Debug and the standard library logger is redirected to it.
+func WithLogger(logger *zap.Logger) Option {
+	return namerouter.WithLogger(logger)
+}
+
+// WithCertCache sets where certificates from Letsencrypt are stored.
+// Without it, certificates are only kept in memory.
+func WithCertCache(cache autocert.Cache) Option {
+	return namerouter.WithCertCache(cache)
+}
+
+// WithHealthServer starts a health check server on addr. There is no health
+// server without it.
+func WithHealthServer(addr string) Option {
+	return namerouter.WithHealthServer(addr)
+}
+
+// WithHTTPListener makes Start serve HTTP on l instead of the httpPort
+func WithHTTPListener(l net.Listener) Option {
+	return namerouter.WithHTTPListener(l)
+}
+
+// WithHTTPSListener makes Start serve HTTPS on l instead of the httpsPort
+func WithHTTPSListener(l net.Listener) Option {
+	return namerouter.WithHTTPSListener(l)
+}
+
+// WithMiddleware adds middleware that runs after the global middleware
+// chain, just before a request is routed
+func WithMiddleware(mw ...mux.MiddlewareFunc) Option {
+	return namerouter.WithMiddleware(mw...)
+}
+
+// ClientFromContext returns the client that sent a request, which the
+// router stores in the request context before any middleware runs
+func ClientFromContext(ctx context.Context) (*Client, bool) {
+	return namerouter.ClientFromContext(ctx)
+}
+
+// RegisterMiddleware registers a middleware under a name, so that it can be
+// used in Config.Middlewares and by routes. It is usually called from an
+// init function, before any config is loaded.
+func RegisterMiddleware(name string, mw mux.MiddlewareFunc) error {
+	return namerouter.RegisterMiddleware(name, mw)
+}